@@ -0,0 +1,74 @@
+package cert
+
+import (
+	"crypto/ed25519"
+	"encoding/pem"
+	"io/ioutil"
+
+	"github.com/xaionaro-go/errors"
+)
+
+// WriteCA PEM-encodes ca's public and private keys to separate files, the
+// private key with 0600 permissions.
+func WriteCA(ca *CA, publicPath, privatePath string) error {
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: PEMTypeCAPublic, Bytes: ca.Public})
+	if err := ioutil.WriteFile(publicPath, publicPEM, 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: PEMTypeCAPrivate, Bytes: ca.Private})
+	if err := ioutil.WriteFile(privatePath, privatePEM, 0600); err != nil {
+		return errors.Wrap(err)
+	}
+	return nil
+}
+
+// ReadCA reads back a CA written by WriteCA.
+func ReadCA(publicPath, privatePath string) (*CA, error) {
+	publicPEM, err := ioutil.ReadFile(publicPath)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	privatePEM, err := ioutil.ReadFile(privatePath)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	publicBlock, _ := pem.Decode(publicPEM)
+	privateBlock, _ := pem.Decode(privatePEM)
+	if publicBlock == nil || privateBlock == nil {
+		return nil, errors.New("malformed CA key file")
+	}
+
+	return &CA{
+		Public:  ed25519.PublicKey(publicBlock.Bytes),
+		Private: ed25519.PrivateKey(privateBlock.Bytes),
+	}, nil
+}
+
+// ReadBundle loads one or more CA public keys (concatenated PEM blocks)
+// from path, forming the trust bundle a peer verifies other peers' certs
+// against.
+func ReadBundle(path string) (Bundle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	var bundle Bundle
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == PEMTypeCAPublic {
+			bundle = append(bundle, ed25519.PublicKey(block.Bytes))
+		}
+	}
+	if len(bundle) == 0 {
+		return nil, errors.New("no CA public keys found in bundle file", path)
+	}
+	return bundle, nil
+}
@@ -0,0 +1,208 @@
+// Package cert implements a Nebula-v2-style signed certificate for peer
+// identity: each peer carries a CA-signed blob binding its name, public
+// key, assigned overlay CIDR, allowed groups and a validity window. The
+// arbitr distributes these certificates but, unlike the password-hash
+// scheme it replaces, never holds a secret a peer could be impersonated
+// with.
+package cert
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/asn1"
+	"encoding/pem"
+	"net"
+	"time"
+
+	"github.com/xaionaro-go/errors"
+)
+
+const (
+	PEMTypeCert      = "HOMENET PEER CERTIFICATE"
+	PEMTypeCAPublic  = "HOMENET CA PUBLIC KEY"
+	PEMTypeCAPrivate = "HOMENET CA PRIVATE KEY"
+)
+
+var (
+	ErrExpired           = errors.New("certificate has expired")
+	ErrNotYetValid       = errors.New("certificate is not valid yet")
+	ErrBadSignature      = errors.New("certificate signature does not verify against any CA in the bundle")
+	ErrOverlayMismatch   = errors.New("certificate's overlay address does not match the address being programmed")
+	ErrPublicKeyMismatch = errors.New("certificate's public key does not match the peer's transport public key")
+	ErrBadPublicKeyLen   = errors.New("public key must be 32 bytes (an X25519 transport key)")
+)
+
+// PublicKeySize is the length in bytes of the key a Cert binds -- the
+// peer's X25519 transport key (transport.PeerKeys.Public), not its CA
+// signing key. Binding the data-plane key here, rather than some other
+// identity key, is what lets CheckPublicKey actually tie a certificate to
+// the key securing the overlay traffic.
+const PublicKeySize = 32
+
+// payload is the ASN.1 DER-encoded part of a Cert that gets signed. It
+// only uses asn1-friendly types: IPs and durations are stored as their
+// wire/string forms and reconstructed by Cert's accessors.
+type payload struct {
+	PeerName    string
+	PublicKey   []byte // the peer's X25519 transport public key, see PublicKeySize
+	OverlayCIDR string // e.g. "10.10.0.4/24" or "fd00::4/64"
+	Groups      []string
+	NotBefore   int64 // unix seconds
+	NotAfter    int64 // unix seconds
+}
+
+// signedCert is what actually gets PEM-encoded: the signed payload plus
+// the Ed25519 signature produced over its DER encoding.
+type signedCert struct {
+	Payload   []byte // DER-encoded payload
+	Signature []byte
+}
+
+// Cert is a verified, decoded peer certificate.
+type Cert struct {
+	PeerName  string
+	PublicKey []byte // the peer's X25519 transport public key, see PublicKeySize
+	Overlay   *net.IPNet
+	Groups    []string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// CA is a certificate authority keypair, able to sign peer certificates.
+type CA struct {
+	Public  ed25519.PublicKey
+	Private ed25519.PrivateKey
+}
+
+// GenerateCA creates a fresh Ed25519 CA keypair.
+func GenerateCA() (*CA, error) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return &CA{Public: public, Private: private}, nil
+}
+
+// SignRequest is what the requester (the peer) hands the CA operator to
+// get a certificate minted, typically via `homenet-peer-cert sign`.
+type SignRequest struct {
+	PeerName  string
+	PublicKey []byte // the peer's X25519 transport public key, see PublicKeySize
+	Overlay   *net.IPNet
+	Groups    []string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Sign mints a new certificate for req, signed by ca.
+func (ca *CA) Sign(req SignRequest) ([]byte, error) {
+	if len(req.PublicKey) != PublicKeySize {
+		return nil, errors.Wrap(ErrBadPublicKeyLen, "got", len(req.PublicKey))
+	}
+
+	p := payload{
+		PeerName:    req.PeerName,
+		PublicKey:   req.PublicKey,
+		OverlayCIDR: req.Overlay.String(),
+		Groups:      req.Groups,
+		NotBefore:   req.NotBefore.Unix(),
+		NotAfter:    req.NotAfter.Unix(),
+	}
+	der, err := asn1.Marshal(p)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	sc := signedCert{
+		Payload:   der,
+		Signature: ed25519.Sign(ca.Private, der),
+	}
+	scDER, err := asn1.Marshal(sc)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	block := &pem.Block{Type: PEMTypeCert, Bytes: scDER}
+	return pem.EncodeToMemory(block), nil
+}
+
+// Bundle is the set of CA public keys a peer trusts.
+type Bundle []ed25519.PublicKey
+
+// Verify decodes a PEM-encoded certificate, checks its signature against
+// every CA in bundle, and checks its validity window against now. It does
+// not check the overlay address -- callers that are about to program an
+// address should also call Cert.CheckOverlay.
+func Verify(pemBytes []byte, bundle Bundle, now time.Time) (*Cert, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != PEMTypeCert {
+		return nil, errors.New("not a PEM-encoded homenet peer certificate")
+	}
+
+	var sc signedCert
+	if _, err := asn1.Unmarshal(block.Bytes, &sc); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	var verified bool
+	for _, ca := range bundle {
+		if ed25519.Verify(ca, sc.Payload, sc.Signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.Wrap(ErrBadSignature)
+	}
+
+	var p payload
+	if _, err := asn1.Unmarshal(sc.Payload, &p); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	overlayIP, overlay, err := net.ParseCIDR(p.OverlayCIDR)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	overlay.IP = overlayIP // keep the peer's own address, not just the network
+
+	c := &Cert{
+		PeerName:  p.PeerName,
+		PublicKey: p.PublicKey,
+		Overlay:   overlay,
+		Groups:    p.Groups,
+		NotBefore: time.Unix(p.NotBefore, 0),
+		NotAfter:  time.Unix(p.NotAfter, 0),
+	}
+
+	if now.Before(c.NotBefore) {
+		return nil, errors.Wrap(ErrNotYetValid, "not-before", c.NotBefore)
+	}
+	if now.After(c.NotAfter) {
+		return nil, errors.Wrap(ErrExpired, "not-after", c.NotAfter)
+	}
+
+	return c, nil
+}
+
+// CheckOverlay refuses a cert whose bound overlay address doesn't match
+// the address updateIPAddress is about to program for this peer.
+func (c *Cert) CheckOverlay(wantIP net.IP) error {
+	if !c.Overlay.IP.Equal(wantIP) {
+		return errors.Wrap(ErrOverlayMismatch, "cert overlay", c.Overlay.IP, "expected", wantIP)
+	}
+	return nil
+}
+
+// CheckPublicKey refuses a cert that isn't bound to wantPublicKey, the
+// peer's actual X25519 transport key (transport.PeerKeys.Public). Without
+// this check a validly-signed certificate for one peer could be presented
+// alongside any transport key at all -- the signature proves the CA
+// vouched for *some* peer, not that this connection is with the peer it
+// vouched for.
+func (c *Cert) CheckPublicKey(wantPublicKey [32]byte) error {
+	if !bytes.Equal(c.PublicKey, wantPublicKey[:]) {
+		return errors.Wrap(ErrPublicKeyMismatch, "cert public key", c.PublicKey, "expected", wantPublicKey[:])
+	}
+	return nil
+}
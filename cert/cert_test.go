@@ -0,0 +1,134 @@
+package cert
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("net.ParseCIDR(%q): %s", cidr, err)
+	}
+	return n
+}
+
+func mustSign(t *testing.T, ca *CA, req SignRequest) []byte {
+	t.Helper()
+	pemBytes, err := ca.Sign(req)
+	if err != nil {
+		t.Fatalf("ca.Sign: %s", err)
+	}
+	return pemBytes
+}
+
+func TestSignRejectsBadPublicKeyLen(t *testing.T) {
+	ca, err := GenerateCA()
+	if err != nil {
+		t.Fatalf("GenerateCA: %s", err)
+	}
+
+	_, err = ca.Sign(SignRequest{
+		PeerName:  "peer0",
+		PublicKey: make([]byte, 16), // not a 32-byte X25519 key
+		Overlay:   mustParseCIDR(t, "10.10.0.4/24"),
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	})
+	if err == nil {
+		t.Fatalf("ca.Sign with a 16-byte public key returned no error, want ErrBadPublicKeyLen")
+	}
+}
+
+func TestVerifyAndCheckPublicKey(t *testing.T) {
+	ca, err := GenerateCA()
+	if err != nil {
+		t.Fatalf("GenerateCA: %s", err)
+	}
+
+	var peerKey, otherKey [32]byte
+	peerKey[0] = 1
+	otherKey[0] = 2
+
+	now := time.Now()
+	pemBytes := mustSign(t, ca, SignRequest{
+		PeerName:  "peer0",
+		PublicKey: peerKey[:],
+		Overlay:   mustParseCIDR(t, "10.10.0.4/24"),
+		NotBefore: now.Add(-time.Minute),
+		NotAfter:  now.Add(time.Hour),
+	})
+
+	c, err := Verify(pemBytes, Bundle{ca.Public}, now)
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+
+	if err := c.CheckPublicKey(peerKey); err != nil {
+		t.Fatalf("CheckPublicKey(bound key) returned error: %s", err)
+	}
+	if err := c.CheckPublicKey(otherKey); err == nil {
+		t.Fatalf("CheckPublicKey(unrelated key) returned no error, want ErrPublicKeyMismatch")
+	}
+}
+
+func TestVerifyRejectsExpiredAndNotYetValid(t *testing.T) {
+	ca, err := GenerateCA()
+	if err != nil {
+		t.Fatalf("GenerateCA: %s", err)
+	}
+
+	var peerKey [32]byte
+	peerKey[0] = 1
+	now := time.Now()
+
+	expired := mustSign(t, ca, SignRequest{
+		PeerName:  "peer0",
+		PublicKey: peerKey[:],
+		Overlay:   mustParseCIDR(t, "10.10.0.4/24"),
+		NotBefore: now.Add(-2 * time.Hour),
+		NotAfter:  now.Add(-time.Hour),
+	})
+	if _, err := Verify(expired, Bundle{ca.Public}, now); err == nil {
+		t.Fatalf("Verify(expired cert) returned no error")
+	}
+
+	notYetValid := mustSign(t, ca, SignRequest{
+		PeerName:  "peer0",
+		PublicKey: peerKey[:],
+		Overlay:   mustParseCIDR(t, "10.10.0.4/24"),
+		NotBefore: now.Add(time.Hour),
+		NotAfter:  now.Add(2 * time.Hour),
+	})
+	if _, err := Verify(notYetValid, Bundle{ca.Public}, now); err == nil {
+		t.Fatalf("Verify(not-yet-valid cert) returned no error")
+	}
+}
+
+func TestVerifyRejectsSignatureFromUntrustedCA(t *testing.T) {
+	ca, err := GenerateCA()
+	if err != nil {
+		t.Fatalf("GenerateCA: %s", err)
+	}
+	otherCA, err := GenerateCA()
+	if err != nil {
+		t.Fatalf("GenerateCA: %s", err)
+	}
+
+	var peerKey [32]byte
+	peerKey[0] = 1
+	now := time.Now()
+	pemBytes := mustSign(t, ca, SignRequest{
+		PeerName:  "peer0",
+		PublicKey: peerKey[:],
+		Overlay:   mustParseCIDR(t, "10.10.0.4/24"),
+		NotBefore: now.Add(-time.Minute),
+		NotAfter:  now.Add(time.Hour),
+	})
+
+	if _, err := Verify(pemBytes, Bundle{otherCA.Public}, now); err == nil {
+		t.Fatalf("Verify against an untrusted CA bundle returned no error, want ErrBadSignature")
+	}
+}
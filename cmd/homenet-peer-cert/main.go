@@ -0,0 +1,146 @@
+// Command homenet-peer-cert generates CA keys, signs peer certificates
+// against a CA, and prints the contents of an existing certificate. It
+// replaces the shared-password-file workflow from the daemon's perspective:
+// the arbitr only ever sees (and distributes) these certificates.
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xaionaro-go/homenet-peer/cert"
+)
+
+func fatalIf(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err.Error())
+		os.Exit(1)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "ca":
+		cmdCA(os.Args[2:])
+	case "sign":
+		cmdSign(os.Args[2:])
+	case "print":
+		cmdPrint(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  homenet-peer-cert ca -pub ca.pub -key ca.key
+  homenet-peer-cert sign -ca-pub ca.pub -ca-key ca.key -name peer0 -pub peer0.pub -overlay 10.10.0.4/24 -group home -ttl 8760h -out peer0.cert
+  homenet-peer-cert print -cert peer0.cert -ca-bundle ca.pub`)
+}
+
+func cmdCA(args []string) {
+	fs := flag.NewFlagSet("ca", flag.ExitOnError)
+	pubPath := fs.String("pub", "ca.pub", "path to write the CA public key to")
+	keyPath := fs.String("key", "ca.key", "path to write the CA private key to")
+	fs.Parse(args)
+
+	ca, err := cert.GenerateCA()
+	fatalIf(err)
+	fatalIf(cert.WriteCA(ca, *pubPath, *keyPath))
+	fmt.Printf("wrote CA public key to %s and private key to %s\n", *pubPath, *keyPath)
+}
+
+func cmdSign(args []string) {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	caPubPath := fs.String("ca-pub", "ca.pub", "path to the CA public key")
+	caKeyPath := fs.String("ca-key", "ca.key", "path to the CA private key")
+	name := fs.String("name", "", "peer name")
+	pubB64 := fs.String("pub", "", "peer's X25519 transport public key, base64")
+	overlay := fs.String("overlay", "", "overlay CIDR assigned to the peer, e.g. 10.10.0.4/24")
+	group := fs.String("group", "", "comma-separated list of allowed groups")
+	ttl := fs.Duration("ttl", 365*24*time.Hour, "certificate validity duration")
+	out := fs.String("out", "", "path to write the signed certificate to (stdout if empty)")
+	fs.Parse(args)
+
+	if *name == "" || *pubB64 == "" || *overlay == "" {
+		usage()
+		os.Exit(2)
+	}
+
+	ca, err := cert.ReadCA(*caPubPath, *caKeyPath)
+	fatalIf(err)
+
+	pubKey, err := base64.StdEncoding.DecodeString(*pubB64)
+	fatalIf(err)
+
+	overlayIP, overlayNet, err := net.ParseCIDR(*overlay)
+	fatalIf(err)
+	overlayNet.IP = overlayIP // bind the peer's own address, not just the network
+
+	var groups []string
+	for _, g := range strings.Split(*group, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+
+	now := time.Now()
+	signed, err := ca.Sign(cert.SignRequest{
+		PeerName:  *name,
+		PublicKey: pubKey,
+		Overlay:   overlayNet,
+		Groups:    groups,
+		NotBefore: now,
+		NotAfter:  now.Add(*ttl),
+	})
+	fatalIf(err)
+
+	if *out == "" {
+		os.Stdout.Write(signed)
+		return
+	}
+	fatalIf(os.WriteFile(*out, signed, 0644))
+	fmt.Printf("wrote certificate for %q to %s\n", *name, *out)
+}
+
+func cmdPrint(args []string) {
+	fs := flag.NewFlagSet("print", flag.ExitOnError)
+	certPath := fs.String("cert", "", "path to the certificate to print")
+	bundlePath := fs.String("ca-bundle", "", "path to the CA bundle to verify against")
+	fs.Parse(args)
+
+	if *certPath == "" || *bundlePath == "" {
+		usage()
+		os.Exit(2)
+	}
+
+	certPEM, err := os.ReadFile(*certPath)
+	fatalIf(err)
+
+	bundle, err := cert.ReadBundle(*bundlePath)
+	fatalIf(err)
+
+	c, err := cert.Verify(certPEM, bundle, time.Now())
+	if err != nil {
+		fmt.Printf("INVALID: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("peer name:  %s\n", c.PeerName)
+	fmt.Printf("public key: %s\n", base64.StdEncoding.EncodeToString(c.PublicKey))
+	fmt.Printf("overlay:    %s\n", c.Overlay.String())
+	fmt.Printf("groups:     %v\n", c.Groups)
+	fmt.Printf("not before: %s\n", c.NotBefore.Format(time.RFC3339))
+	fmt.Printf("not after:  %s\n", c.NotAfter.Format(time.RFC3339))
+}
@@ -1,20 +1,22 @@
 package main
 
 import (
+	"crypto/sha1"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/denisbrodbeck/machineid"
 
+	"github.com/xaionaro-go/homenet-peer/cert"
 	"github.com/xaionaro-go/homenet-peer/config"
 	"github.com/xaionaro-go/homenet-peer/connector"
-	"github.com/xaionaro-go/homenet-peer/helpers"
+	"github.com/xaionaro-go/homenet-peer/discovery"
+	"github.com/xaionaro-go/homenet-peer/firewall"
 	"github.com/xaionaro-go/homenet-peer/negotiator"
 	"github.com/xaionaro-go/homenet-peer/network"
 	"github.com/xaionaro-go/homenet-peer/vpn"
@@ -53,15 +55,22 @@ func main() {
 		apiOptions = append(apiOptions, api.OptSetLoggerDebug(&debugLogger{}))
 	}
 
-	passwordFile := config.Get().PasswordFile
-	password, err := ioutil.ReadFile(passwordFile)
+	netLogger := &logger{config.Get().DumpNetworkCommunications}
+
+	peerCertFile := config.Get().PeerCertFile
+	peerCertPEM, err := ioutil.ReadFile(peerCertFile)
 	if err != nil {
-		panic(fmt.Errorf(`cannot read the password file "%v"`, passwordFile))
+		panic(fmt.Errorf(`cannot read the peer certificate file "%v"`, peerCertFile))
 	}
 
+	caBundle, err := cert.ReadBundle(config.Get().CABundleFile)
+	fatalIf(err)
+	certVerifier := connector.NewCertVerifier(caBundle, netLogger)
+
 	networkID := config.Get().NetworkID
-	passwordHashHash := string(helpers.Hash([]byte(strings.Trim(string(password), " \t\n\r"))))
-	homenetServer := api.New(config.Get().ArbitrURL, passwordHashHash, apiOptions...)
+	// The arbitr only ever sees and redistributes this certificate -- it is
+	// no longer a secret holder, unlike the passwordHashHash it replaces.
+	homenetServer := api.New(config.Get().ArbitrURL, string(peerCertPEM), apiOptions...)
 	status, netInfo, err := homenetServer.GetNet(networkID)
 	fatalIf(err)
 	switch status {
@@ -77,21 +86,80 @@ func main() {
 		vpnOptions = append(vpnOptions, vpn.OptSetLoggerDump(&debugLogger{}))
 	}
 
+	if rulesFile := config.Get().FirewallRulesFile; rulesFile != "" {
+		rules, remoteAllow, err := firewall.ReadRulesFile(rulesFile)
+		fatalIf(err)
+		firewallTable := firewall.NewTable(rules, remoteAllow, firewall.NoopMetrics{})
+		firewall.WatchSIGHUP(firewallTable, func() ([]firewall.DirectedRule, []*net.IPNet, error) {
+			return firewall.ReadRulesFile(rulesFile)
+		}, func(err error) {
+			logrus.Errorf("firewall: reload of %s failed: %s", rulesFile, err.Error())
+		})
+		vpnOptions = append(vpnOptions, vpn.OptSetFirewallTable(firewallTable))
+	}
+
+	// NAT traversal runs on its own probe socket rather than the datapath
+	// socket transport.Transport owns: the puncher needs an exclusive read
+	// loop to demux STUN messages by transaction ID, which would otherwise
+	// race transport's own receive loop for every datagram on a shared
+	// socket.
+	if natConn, err := net.ListenUDP("udp", nil); err != nil {
+		logrus.Errorf("unable to open a NAT-traversal probe socket: %s", err.Error())
+	} else {
+		natTraversal, err := connector.NewNATTraversal(natConn, connector.NATTraversalConfig{
+			StunServers: config.Get().STUNServers,
+		}, netLogger)
+		if err != nil {
+			logrus.Errorf("connector.NewNATTraversal: %s", err.Error())
+			natConn.Close()
+		} else {
+			vpnOptions = append(vpnOptions, vpn.OptSetEndpointNegotiator(natTraversal))
+		}
+	}
+
+	if rulesFile := config.Get().CalculatedRemoteRulesFile; rulesFile != "" {
+		rules, err := connector.ReadCalculatedRemoteRulesFile(rulesFile)
+		fatalIf(err)
+		vpnOptions = append(vpnOptions, vpn.OptSetCalculatedRemoteRules(rules))
+	}
+
 	_, subnet, err := net.ParseCIDR(config.Get().NetworkSubnet)
 	fatalIf(err)
 
-	netLogger := &logger{config.Get().DumpNetworkCommunications}
-
 	homenet, err := network.New(nil, netLogger)
 	fatalIf(err)
 
-	connectorInstance := connector.New(negotiator.New(config.Get().NetworkUpdateInterval, homenetServer, networkID, homenet, netLogger), netLogger)
+	// The arbitr is still authoritative for cert distribution, but if it's
+	// unreachable this DHT backend keeps the overlay discoverable: peers
+	// announce_peer their UDP endpoint and get_peers the rest, keyed by a
+	// hash of the network ID and the CA bundle every peer of this network
+	// was provisioned with -- the same value on every peer, unlike a
+	// per-peer certificate, so they actually land in the same swarm.
+	var caBundleBytes []byte
+	for _, pub := range caBundle {
+		caBundleBytes = append(caBundleBytes, pub...)
+	}
+	dhtInfoHash := fmt.Sprintf("%x", sha1.Sum(append([]byte(networkID), caBundleBytes...)))
+	dht, err := discovery.NewDHT(config.Get().DHTListenAddr, dhtInfoHash, homenet.GetPeerID(), config.Get().DHTBootstrapNodes, netLogger)
+	fatalIf(err)
+	discoveryBackend := discovery.NewAggregator(dht)
+
+	connectorInstance := connector.New(negotiator.New(config.Get().NetworkUpdateInterval, homenetServer, networkID, homenet, netLogger, discoveryBackend), netLogger, certVerifier)
 
 	homenet.SetConnector(connectorInstance)
 
-	_, err = vpn.New(*subnet, homenet, vpnOptions...)
+	vpnInstance, err := vpn.New(*subnet, homenet, vpnOptions...)
 	fatalIf(err)
 
+	// Per-peer endpoints are now hole-punched via vpn.OptSetEndpointNegotiator
+	// above; what we announce here is just our own address for other peers
+	// to list as a candidate, so the raw local address is still right.
+	if ourEndpoint, err := net.ResolveUDPAddr("udp", vpnInstance.GetListenAddr()); err == nil {
+		if err := discoveryBackend.Announce(ourEndpoint); err != nil {
+			logrus.Errorf("discoveryBackend.Announce(%s): %s", ourEndpoint, err.Error())
+		}
+	}
+
 	hostname, _ := os.Hostname()
 	machineID, _ := machineid.ProtectedID("homenet-peer")
 	if len(machineID) > MachineIDLength {
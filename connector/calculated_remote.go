@@ -0,0 +1,97 @@
+package connector
+
+import (
+	"net"
+
+	"github.com/xaionaro-go/errors"
+
+	"github.com/xaionaro-go/homenet-peer/nat"
+)
+
+var (
+	ErrMismatchedPrefixLength = errors.New("overlay_prefix and mask_prefix must have the same bit length")
+	ErrPortOutOfRange         = errors.New("port must be in [1, 65535]")
+)
+
+// CalculatedRemoteRule lets an admin configure an instant "best guess"
+// underlay endpoint for a peer known only by its overlay address, for
+// overlays deployed on top of a predictable underlay (Nebula calls this a
+// calculatedRemote). The synthesized endpoint is tried in parallel with
+// arbitr- and DHT-learned candidates, and dropped once a real one succeeds.
+type CalculatedRemoteRule struct {
+	OverlayPrefix *net.IPNet
+	MaskPrefix    *net.IPNet
+	Port          uint16
+}
+
+// Validate checks that OverlayPrefix and MaskPrefix have matching bit
+// length and that Port is a valid, non-zero UDP port.
+func (r CalculatedRemoteRule) Validate() error {
+	overlayBits := prefixBitLen(r.OverlayPrefix)
+	maskBits := prefixBitLen(r.MaskPrefix)
+	if overlayBits != maskBits {
+		return errors.Wrap(ErrMismatchedPrefixLength, "overlay bits", overlayBits, "mask bits", maskBits)
+	}
+	if r.Port == 0 {
+		return errors.Wrap(ErrPortOutOfRange, r.Port)
+	}
+	return nil
+}
+
+func prefixBitLen(n *net.IPNet) int {
+	ones, _ := n.Mask.Size()
+	return ones
+}
+
+// Calculate synthesizes a candidate underlay endpoint for peerOverlayIP by
+// taking the masked (network) bits of MaskPrefix and OR-ing in the
+// unmasked (host) bits of peerOverlayIP.
+func (r CalculatedRemoteRule) Calculate(peerOverlayIP net.IP) (*net.UDPAddr, error) {
+	if err := r.Validate(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	maskIP := r.MaskPrefix.IP.To16()
+	mask := r.MaskPrefix.Mask
+	overlayIP := peerOverlayIP.To16()
+	if maskIP == nil || overlayIP == nil {
+		return nil, errors.New("unable to normalize addresses to 16 bytes")
+	}
+
+	// mask.Size() is in terms of the mask's own byte length (4 or 16); we
+	// need a full-width (16-byte) mask to combine 16-byte addresses.
+	fullMask := to16ByteMask(mask)
+
+	result := make(net.IP, 16)
+	for i := 0; i < 16; i++ {
+		result[i] = (maskIP[i] & fullMask[i]) | (overlayIP[i] &^ fullMask[i])
+	}
+
+	return &net.UDPAddr{IP: result, Port: int(r.Port)}, nil
+}
+
+func to16ByteMask(mask net.IPMask) net.IPMask {
+	if len(mask) == 16 {
+		return mask
+	}
+	ones, _ := mask.Size()
+	return net.CIDRMask(ones+96, 128)
+}
+
+// CalculatedRemotes evaluates every configured rule whose OverlayPrefix
+// contains peerOverlayIP and returns the resulting candidates, ready to be
+// raced against arbitr/DHT candidates by nat.Puncher.
+func CalculatedRemotes(rules []CalculatedRemoteRule, peerOverlayIP net.IP) []nat.Candidate {
+	var candidates []nat.Candidate
+	for _, rule := range rules {
+		if !rule.OverlayPrefix.Contains(peerOverlayIP) {
+			continue
+		}
+		addr, err := rule.Calculate(peerOverlayIP)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, nat.Candidate{Type: nat.CandidateCalculated, Addr: addr})
+	}
+	return candidates
+}
@@ -0,0 +1,55 @@
+package connector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+
+	"github.com/xaionaro-go/errors"
+)
+
+// jsonCalculatedRemoteRule is the on-disk shape of one CalculatedRemoteRule.
+type jsonCalculatedRemoteRule struct {
+	OverlayPrefix string `json:"overlay_prefix"`
+	MaskPrefix    string `json:"mask_prefix"`
+	Port          uint16 `json:"port"`
+}
+
+// ReadCalculatedRemoteRulesFile loads a set of CalculatedRemoteRules from a
+// JSON file (a top-level array of jsonCalculatedRemoteRule), in the shape
+// cmd/homenet reads once at startup to populate vpn.OptSetCalculatedRemoteRules.
+func ReadCalculatedRemoteRulesFile(path string) ([]CalculatedRemoteRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	var jsonRules []jsonCalculatedRemoteRule
+	if err := json.Unmarshal(data, &jsonRules); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	rules := make([]CalculatedRemoteRule, 0, len(jsonRules))
+	for _, jr := range jsonRules {
+		_, overlayPrefix, err := net.ParseCIDR(jr.OverlayPrefix)
+		if err != nil {
+			return nil, errors.Wrap(err, "overlay_prefix", jr.OverlayPrefix)
+		}
+		_, maskPrefix, err := net.ParseCIDR(jr.MaskPrefix)
+		if err != nil {
+			return nil, errors.Wrap(err, "mask_prefix", jr.MaskPrefix)
+		}
+
+		rule := CalculatedRemoteRule{
+			OverlayPrefix: overlayPrefix,
+			MaskPrefix:    maskPrefix,
+			Port:          jr.Port,
+		}
+		if err := rule.Validate(); err != nil {
+			return nil, errors.Wrap(err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,85 @@
+package connector
+
+import (
+	"net"
+	"testing"
+
+	"github.com/xaionaro-go/homenet-peer/nat"
+)
+
+func mustParseCIDRForTest(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("net.ParseCIDR(%q): %s", cidr, err)
+	}
+	return n
+}
+
+// TestPrefixBitLenUsesMaskBits guards against a prior bug where
+// prefixBitLen returned len(n.IP.To16())*8, always 128 regardless of the
+// mask's actual prefix length -- which made Validate's bit-length-mismatch
+// check unable to ever fail, even comparing an IPv4 /24 to an IPv6 /64.
+func TestPrefixBitLenUsesMaskBits(t *testing.T) {
+	if got := prefixBitLen(mustParseCIDRForTest(t, "10.0.0.0/24")); got != 24 {
+		t.Fatalf("prefixBitLen(10.0.0.0/24) = %d, want 24", got)
+	}
+	if got := prefixBitLen(mustParseCIDRForTest(t, "fd00::/64")); got != 64 {
+		t.Fatalf("prefixBitLen(fd00::/64) = %d, want 64", got)
+	}
+}
+
+func TestCalculatedRemoteRuleValidateRejectsMismatchedPrefixLengths(t *testing.T) {
+	rule := CalculatedRemoteRule{
+		OverlayPrefix: mustParseCIDRForTest(t, "10.0.0.0/24"),
+		MaskPrefix:    mustParseCIDRForTest(t, "fd00::/64"),
+		Port:          4242,
+	}
+	if err := rule.Validate(); err == nil {
+		t.Fatalf("Validate() with mismatched prefix lengths returned no error")
+	}
+}
+
+func TestCalculatedRemoteRuleCalculate(t *testing.T) {
+	rule := CalculatedRemoteRule{
+		OverlayPrefix: mustParseCIDRForTest(t, "10.10.0.0/24"),
+		MaskPrefix:    mustParseCIDRForTest(t, "203.0.113.0/24"),
+		Port:          51820,
+	}
+
+	addr, err := rule.Calculate(net.ParseIP("10.10.0.42"))
+	if err != nil {
+		t.Fatalf("Calculate: %s", err)
+	}
+	want := &net.UDPAddr{IP: net.ParseIP("203.0.113.42"), Port: 51820}
+	if !addr.IP.Equal(want.IP) || addr.Port != want.Port {
+		t.Fatalf("Calculate(10.10.0.42) = %s, want %s", addr, want)
+	}
+}
+
+func TestCalculatedRemotesSkipsRulesThatDontContainThePeer(t *testing.T) {
+	rules := []CalculatedRemoteRule{
+		{
+			OverlayPrefix: mustParseCIDRForTest(t, "10.10.0.0/24"),
+			MaskPrefix:    mustParseCIDRForTest(t, "203.0.113.0/24"),
+			Port:          51820,
+		},
+		{
+			OverlayPrefix: mustParseCIDRForTest(t, "10.20.0.0/24"),
+			MaskPrefix:    mustParseCIDRForTest(t, "198.51.100.0/24"),
+			Port:          51820,
+		},
+	}
+
+	got := CalculatedRemotes(rules, net.ParseIP("10.10.0.42"))
+	if len(got) != 1 {
+		t.Fatalf("CalculatedRemotes returned %d candidates, want 1", len(got))
+	}
+	if got[0].Type != nat.CandidateCalculated {
+		t.Fatalf("candidate type = %v, want CandidateCalculated", got[0].Type)
+	}
+	want := net.ParseIP("203.0.113.42")
+	if !got[0].Addr.IP.Equal(want) || got[0].Addr.Port != 51820 {
+		t.Fatalf("candidate addr = %s, want %s:51820", got[0].Addr, want)
+	}
+}
@@ -0,0 +1,72 @@
+package connector
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xaionaro-go/errors"
+
+	"github.com/xaionaro-go/homenet-peer/cert"
+)
+
+// CertVerifier verifies peer certificates learned via GetPeers against a
+// CA bundle and caches the result, so connector no longer needs the
+// arbitr-held passwordHashHash to trust a peer's identity.
+type CertVerifier struct {
+	bundle cert.Bundle
+	logger Logger
+
+	mu    sync.RWMutex
+	cache map[string]*cert.Cert // keyed by peer ID
+}
+
+func NewCertVerifier(bundle cert.Bundle, logger Logger) *CertVerifier {
+	return &CertVerifier{
+		bundle: bundle,
+		logger: logger,
+		cache:  map[string]*cert.Cert{},
+	}
+}
+
+// Verify checks certPEM's signature and validity window, and refuses it if
+// its bound overlay address doesn't match wantOverlayIP (the address
+// vpn.updateIPAddress is about to program for this peer) or its bound
+// public key doesn't match wantPublicKey (the peer's actual X25519
+// transport key, e.g. from models.PeerT.GetPublicKey()) -- without the
+// latter check, a validly-signed cert for one peer could be paired with
+// any transport key at all and sail through. On success the verified cert
+// is cached under peerID and returned.
+func (v *CertVerifier) Verify(peerID string, certPEM []byte, wantOverlayIP net.IP, wantPublicKey [32]byte) (*cert.Cert, error) {
+	c, err := cert.Verify(certPEM, v.bundle, time.Now())
+	if err != nil {
+		v.logger.Error("rejecting certificate for peer ", peerID, ": ", err.Error())
+		return nil, errors.Wrap(err)
+	}
+
+	if wantOverlayIP != nil {
+		if err := c.CheckOverlay(wantOverlayIP); err != nil {
+			v.logger.Error("rejecting certificate for peer ", peerID, ": ", err.Error())
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	if err := c.CheckPublicKey(wantPublicKey); err != nil {
+		v.logger.Error("rejecting certificate for peer ", peerID, ": ", err.Error())
+		return nil, errors.Wrap(err)
+	}
+
+	v.mu.Lock()
+	v.cache[peerID] = c
+	v.mu.Unlock()
+
+	return c, nil
+}
+
+// Cached returns the previously verified certificate for peerID, if any.
+func (v *CertVerifier) Cached(peerID string) (*cert.Cert, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	c, ok := v.cache[peerID]
+	return c, ok
+}
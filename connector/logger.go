@@ -1,7 +1,17 @@
 package connector
 
+import "github.com/xaionaro-go/homenet-peer/nat"
+
 type Logger interface {
 	Error(...interface{})
 	Infof(string, ...interface{})
 	Debugf(string, ...interface{})
+
+	// OnCandidateGathered is called every time a new NAT traversal
+	// candidate (host, server-reflexive or relay) was gathered for peerID.
+	OnCandidateGathered(peerID string, candidate nat.Candidate)
+
+	// OnPairStateChanged is called every time a candidate pair being
+	// punched for peerID transitions state (waiting/in-progress/succeeded/failed).
+	OnPairStateChanged(peerID string, pair nat.CandidatePair)
 }
@@ -0,0 +1,98 @@
+package connector
+
+import (
+	"net"
+
+	"github.com/xaionaro-go/errors"
+
+	"github.com/xaionaro-go/homenet-peer/nat"
+)
+
+// NATTraversalConfig configures the STUN/TURN NAT traversal helper. It is
+// read from the same config source as the rest of connector's options.
+type NATTraversalConfig struct {
+	StunServers []string
+	TurnRelay   string
+}
+
+// loggerHooks adapts a connector.Logger to nat.Hooks, so the puncher can
+// report candidate/pair events through the logger everything else here
+// already uses.
+type loggerHooks struct {
+	logger Logger
+}
+
+func (h loggerHooks) OnCandidateGathered(peerID string, candidate nat.Candidate) {
+	h.logger.OnCandidateGathered(peerID, candidate)
+	h.logger.Debugf("nat: gathered %s candidate %s for peer %s", candidate.Type, candidate.Addr, peerID)
+}
+
+func (h loggerHooks) OnPairStateChanged(peerID string, pair nat.CandidatePair) {
+	h.logger.OnPairStateChanged(peerID, pair)
+	h.logger.Debugf("nat: pair %s<->%s for peer %s is now %s", pair.Local.Addr, pair.Remote.Addr, peerID, pair.State)
+}
+
+// NATTraversal runs STUN-based candidate gathering and hole punching on the
+// socket it was built with, and is the piece that ends up promoting a
+// winning candidate pair to the transport layer as the peer's endpoint.
+type NATTraversal struct {
+	puncher *nat.Puncher
+	logger  Logger
+}
+
+// NewNATTraversal builds a NATTraversal bound to conn, a probe socket that
+// must not be the datapath socket (see nat.Puncher).
+func NewNATTraversal(conn *net.UDPConn, cfg NATTraversalConfig, logger Logger) (*NATTraversal, error) {
+	opts := nat.Options{}
+	for _, s := range cfg.StunServers {
+		addr, err := net.ResolveUDPAddr("udp", s)
+		if err != nil {
+			return nil, errors.Wrap(err, "stun server", s)
+		}
+		opts.StunServers = append(opts.StunServers, addr)
+	}
+	if cfg.TurnRelay != "" {
+		addr, err := net.ResolveUDPAddr("udp", cfg.TurnRelay)
+		if err != nil {
+			return nil, errors.Wrap(err, "turn relay", cfg.TurnRelay)
+		}
+		opts.TurnRelay = addr
+	}
+
+	return &NATTraversal{
+		puncher: nat.NewPuncher(conn, opts, loggerHooks{logger: logger}),
+		logger:  logger,
+	}, nil
+}
+
+// NegotiateEndpoint gathers our local candidates, publishes them via
+// publish (typically homenetServer.RegisterPeer's candidate list), pulls
+// the remote candidates already known for peerID (arbitr- and DHT-learned,
+// plus any calculated remotes the caller computed via CalculatedRemotes),
+// and punches a hole to find a working pair. Calculated remotes are raced
+// alongside the rest and simply lose the race once a real candidate pair
+// succeeds -- there's nothing more to "drop", the loser pairs are just
+// abandoned. It returns the winning remote endpoint, ready to be handed to
+// the transport layer.
+func (t *NATTraversal) NegotiateEndpoint(peerID string, hostAddr *net.UDPAddr, remoteCandidates []nat.Candidate, publish func([]nat.Candidate) error) (*net.UDPAddr, error) {
+	local, err := t.puncher.GatherCandidates(peerID, hostAddr)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if publish != nil {
+		if err := publish(local); err != nil {
+			t.logger.Error("unable to publish candidates for peer ", peerID, ": ", err.Error())
+		}
+	}
+
+	if len(remoteCandidates) == 0 {
+		return nil, errors.New("peer has not published any candidates yet")
+	}
+
+	pair, err := t.puncher.Punch(peerID, local, remoteCandidates)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return pair.Remote.Addr, nil
+}
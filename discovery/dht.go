@@ -0,0 +1,289 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xaionaro-go/errors"
+)
+
+// Logger matches the logger shape already used by the vpn package.
+type Logger interface {
+	Printf(fmt string, args ...interface{})
+	Print(args ...interface{})
+}
+
+// message is the DHT's wire format: a small JSON envelope borrowing BEP 5's
+// message names (ping/find_node/get_peers/announce_peer), traded for
+// bencode to keep this self-contained. A node that understands DHT
+// semantics but speaks a different wire format (e.g. anacrolix/dht) is not
+// interchangeable with this one -- this is our own private swarm keyed by
+// InfoHash. Unlike real BEP 5, there is no XOR-distance routing table or
+// k-buckets here: every node just broadcasts to every node it knows about
+// (see broadcast), which is fine for the small, mostly-static swarms a
+// single homenet overlay has, but doesn't scale the way a real Kademlia
+// DHT would to a large, churning swarm.
+type message struct {
+	Type     string `json:"type"` // "ping", "find_node", "get_peers", "announce_peer", "pong", "nodes", "peers"
+	InfoHash string `json:"info_hash,omitempty"`
+	NodeID   string `json:"node_id"`
+	PeerID   string `json:"peer_id,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Nodes    []string `json:"nodes,omitempty"`  // "host:port" of other known nodes
+	Peers    []string `json:"peers,omitempty"`  // "peerID@host:port"
+}
+
+const (
+	announceInterval = 30 * time.Second
+	getPeersInterval = 15 * time.Second
+	peerTTL          = 5 * time.Minute
+)
+
+// DHT is a BEP-5-flavored peer-discovery swarm: every participant
+// announce_peers its UDP endpoint under a shared InfoHash and get_peers
+// the others, the same pattern BitTorrent's mainline DHT uses to find
+// peers for a torrent -- but by flooding every known node rather than
+// routing through a real Kademlia table, see the message doc comment.
+type DHT struct {
+	conn     *net.UDPConn
+	infoHash string
+	selfID   string
+	logger   Logger
+
+	mu            sync.Mutex
+	knownNode     map[string]struct{} // "host:port" of nodes we've heard from
+	peers         map[string]PeerEndpoint
+	lastAnnounced *net.UDPAddr // endpoint passed to the last Announce call, re-sent by maintenanceLoop
+
+	closeChan chan struct{}
+}
+
+// NewDHT joins the swarm identified by infoHash (the caller computes this,
+// typically sha1(networkID || something only trusted peers know), binds a
+// UDP socket, and seeds the known-node set with bootstrap nodes.
+func NewDHT(listenAddr string, infoHash, selfID string, bootstrapNodes []string, logger Logger) (*DHT, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	d := &DHT{
+		conn:      conn,
+		infoHash:  infoHash,
+		selfID:    selfID,
+		logger:    logger,
+		knownNode: map[string]struct{}{},
+		peers:     map[string]PeerEndpoint{},
+		closeChan: make(chan struct{}),
+	}
+	for _, n := range bootstrapNodes {
+		d.knownNode[n] = struct{}{}
+	}
+
+	go d.readLoop()
+	go d.maintenanceLoop()
+	return d, nil
+}
+
+func (d *DHT) Close() error {
+	close(d.closeChan)
+	return d.conn.Close()
+}
+
+// Announce tells every known node about our own UDP endpoint, and caches it
+// so maintenanceLoop can keep re-announcing it on announceTicker without the
+// caller having to call Announce again itself.
+func (d *DHT) Announce(endpoint *net.UDPAddr) error {
+	d.mu.Lock()
+	d.lastAnnounced = endpoint
+	d.mu.Unlock()
+
+	return d.announce(endpoint)
+}
+
+func (d *DHT) announce(endpoint *net.UDPAddr) error {
+	msg := message{
+		Type:     "announce_peer",
+		InfoHash: d.infoHash,
+		NodeID:   d.selfID,
+		PeerID:   d.selfID,
+		Endpoint: endpoint.String(),
+	}
+	return d.broadcast(msg)
+}
+
+// GetPeers asks every known node for their current peer list and returns
+// whatever we've accumulated from past responses (announce_peer/peers
+// messages keep this set updated asynchronously); it also fires a fresh
+// round of get_peers requests so future calls see newer data.
+func (d *DHT) GetPeers() ([]PeerEndpoint, error) {
+	msg := message{
+		Type:     "get_peers",
+		InfoHash: d.infoHash,
+		NodeID:   d.selfID,
+	}
+	if err := d.broadcast(msg); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	peers := make([]PeerEndpoint, 0, len(d.peers))
+	for id, p := range d.peers {
+		if now.Sub(p.LastSeen) > peerTTL {
+			delete(d.peers, id)
+			continue
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+func (d *DHT) broadcast(msg message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	d.mu.Lock()
+	nodes := make([]string, 0, len(d.knownNode))
+	for n := range d.knownNode {
+		nodes = append(nodes, n)
+	}
+	d.mu.Unlock()
+
+	var lastErr error
+	for _, n := range nodes {
+		addr, err := net.ResolveUDPAddr("udp", n)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if _, err := d.conn.WriteToUDP(payload, addr); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (d *DHT) maintenanceLoop() {
+	announceTicker := time.NewTicker(announceInterval)
+	getPeersTicker := time.NewTicker(getPeersInterval)
+	defer announceTicker.Stop()
+	defer getPeersTicker.Stop()
+
+	for {
+		select {
+		case <-d.closeChan:
+			return
+		case <-announceTicker.C:
+			// Re-announcing on our own endpoint keeps us alive in other
+			// nodes' peer tables even if they restarted and lost state --
+			// without this, peers evict us from d.peers after peerTTL (5m)
+			// regardless of how long the arbitr stays unreachable.
+			d.mu.Lock()
+			endpoint := d.lastAnnounced
+			d.mu.Unlock()
+			if endpoint != nil {
+				if err := d.announce(endpoint); err != nil {
+					d.logger.Printf("dht: re-announce failed: %s", err)
+				}
+			}
+		case <-getPeersTicker.C:
+			d.broadcast(message{Type: "get_peers", InfoHash: d.infoHash, NodeID: d.selfID})
+		}
+	}
+}
+
+func (d *DHT) readLoop() {
+	buf := make([]byte, 16*1024)
+	for {
+		select {
+		case <-d.closeChan:
+			return
+		default:
+		}
+
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-d.closeChan:
+				return
+			default:
+			}
+			d.logger.Printf("dht: read error: %s", err)
+			continue
+		}
+
+		var msg message
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			continue
+		}
+		d.handleMessage(msg, from)
+	}
+}
+
+func (d *DHT) handleMessage(msg message, from *net.UDPAddr) {
+	if msg.InfoHash != "" && msg.InfoHash != d.infoHash {
+		return // not our swarm
+	}
+
+	d.mu.Lock()
+	d.knownNode[from.String()] = struct{}{}
+	d.mu.Unlock()
+
+	switch msg.Type {
+	case "announce_peer":
+		endpoint, err := net.ResolveUDPAddr("udp", msg.Endpoint)
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.peers[msg.PeerID] = PeerEndpoint{PeerID: msg.PeerID, Endpoint: endpoint, LastSeen: time.Now()}
+		d.mu.Unlock()
+
+	case "get_peers":
+		d.mu.Lock()
+		peerStrs := make([]string, 0, len(d.peers))
+		for id, p := range d.peers {
+			peerStrs = append(peerStrs, id+"@"+p.Endpoint.String())
+		}
+		d.mu.Unlock()
+		reply := message{Type: "peers", InfoHash: d.infoHash, NodeID: d.selfID, Peers: peerStrs}
+		if payload, err := json.Marshal(reply); err == nil {
+			d.conn.WriteToUDP(payload, from)
+		}
+
+	case "peers":
+		d.mu.Lock()
+		for _, entry := range msg.Peers {
+			id, endpointStr, ok := splitPeerEntry(entry)
+			if !ok {
+				continue
+			}
+			endpoint, err := net.ResolveUDPAddr("udp", endpointStr)
+			if err != nil {
+				continue
+			}
+			d.peers[id] = PeerEndpoint{PeerID: id, Endpoint: endpoint, LastSeen: time.Now()}
+		}
+		d.mu.Unlock()
+	}
+}
+
+func splitPeerEntry(entry string) (peerID, endpoint string, ok bool) {
+	for i := 0; i < len(entry); i++ {
+		if entry[i] == '@' {
+			return entry[:i], entry[i+1:], true
+		}
+	}
+	return "", "", false
+}
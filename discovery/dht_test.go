@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(fmt string, args ...interface{}) {}
+func (discardLogger) Print(args ...interface{})              {}
+
+func mustNewDHT(t *testing.T, infoHash, selfID string, bootstrapNodes []string) *DHT {
+	t.Helper()
+	d, err := NewDHT("127.0.0.1:0", infoHash, selfID, bootstrapNodes, discardLogger{})
+	if err != nil {
+		t.Fatalf("NewDHT: %s", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func waitForPeer(t *testing.T, d *DHT, peerID string) PeerEndpoint {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		p, ok := d.peers[peerID]
+		d.mu.Unlock()
+		if ok {
+			return p
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("peer %q never showed up in d.peers", peerID)
+	return PeerEndpoint{}
+}
+
+// TestAnnounceIsLearnedByBootstrapPeer exercises the basic announce_peer ->
+// handleMessage path used by GetPeers/Announce.
+func TestAnnounceIsLearnedByBootstrapPeer(t *testing.T) {
+	a := mustNewDHT(t, "net1", "peerA", nil)
+	b := mustNewDHT(t, "net1", "peerB", []string{a.conn.LocalAddr().String()})
+
+	bEndpoint := b.conn.LocalAddr().(*net.UDPAddr)
+	if err := b.Announce(bEndpoint); err != nil {
+		t.Fatalf("b.Announce: %s", err)
+	}
+
+	got := waitForPeer(t, a, "peerB")
+	if got.Endpoint.String() != bEndpoint.String() {
+		t.Fatalf("a learned peerB's endpoint as %s, want %s", got.Endpoint, bEndpoint)
+	}
+}
+
+// TestAnnounceCachesLastAnnouncedForReannounce guards against a prior bug
+// where the maintenance loop's periodic re-announce case was a no-op: a
+// peer's own endpoint was only ever announced once, so it silently aged out
+// of every other peer's d.peers after peerTTL regardless of how long the
+// arbitr stayed unreachable. Announce must cache the endpoint so a later
+// internal re-announce (what the ticker case now does) can resend it
+// without the caller announcing again itself.
+func TestAnnounceCachesLastAnnouncedForReannounce(t *testing.T) {
+	a := mustNewDHT(t, "net1", "peerA", nil)
+	b := mustNewDHT(t, "net1", "peerB", []string{a.conn.LocalAddr().String()})
+
+	bEndpoint := b.conn.LocalAddr().(*net.UDPAddr)
+	if err := b.Announce(bEndpoint); err != nil {
+		t.Fatalf("b.Announce: %s", err)
+	}
+	waitForPeer(t, a, "peerB")
+
+	b.mu.Lock()
+	cached := b.lastAnnounced
+	b.mu.Unlock()
+	if cached == nil || cached.String() != bEndpoint.String() {
+		t.Fatalf("b.lastAnnounced = %v, want %s", cached, bEndpoint)
+	}
+
+	// Simulate what maintenanceLoop's announceTicker case now does.
+	if err := b.announce(cached); err != nil {
+		t.Fatalf("b.announce (re-announce): %s", err)
+	}
+	waitForPeer(t, a, "peerB")
+}
+
+// TestGetPeersAsksAndReceives exercises the get_peers/peers round trip: c
+// announces to a (so a.peers learns about c), then b -- which only knows
+// about a, not c -- must learn c's endpoint by asking a via GetPeers.
+func TestGetPeersAsksAndReceives(t *testing.T) {
+	a := mustNewDHT(t, "net1", "peerA", nil)
+	c := mustNewDHT(t, "net1", "peerC", []string{a.conn.LocalAddr().String()})
+	b := mustNewDHT(t, "net1", "peerB", []string{a.conn.LocalAddr().String()})
+
+	cEndpoint := c.conn.LocalAddr().(*net.UDPAddr)
+	if err := c.Announce(cEndpoint); err != nil {
+		t.Fatalf("c.Announce: %s", err)
+	}
+	waitForPeer(t, a, "peerC") // a must first learn about c directly
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		peers, err := b.GetPeers()
+		if err != nil {
+			t.Fatalf("b.GetPeers: %s", err)
+		}
+		for _, p := range peers {
+			if p.PeerID == "peerC" && p.Endpoint.String() == cEndpoint.String() {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("b.GetPeers() never returned peerC's endpoint via peerA")
+}
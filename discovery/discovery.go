@@ -0,0 +1,93 @@
+// Package discovery provides peer-discovery backends that negotiator can
+// fall back to when the central arbitr is unreachable. The arbitr stays
+// authoritative for certificate distribution, but as long as one backend
+// is alive the overlay keeps learning peer endpoints.
+package discovery
+
+import (
+	"net"
+	"time"
+)
+
+// PeerEndpoint is one (peer, endpoint) fact as reported by a Backend.
+type PeerEndpoint struct {
+	PeerID   string
+	Endpoint *net.UDPAddr
+	LastSeen time.Time
+}
+
+// Backend is a pluggable peer-discovery mechanism. negotiator.New can be
+// handed any number of these alongside the arbitr's api client.
+type Backend interface {
+	// Announce publishes our own endpoint to the backend.
+	Announce(endpoint *net.UDPAddr) error
+	// GetPeers returns the backend's current view of peer endpoints.
+	GetPeers() ([]PeerEndpoint, error)
+	Close() error
+}
+
+// Merge combines peer lists from several backends, deduplicating by
+// PeerID and preferring whichever entry has the freshest LastSeen.
+func Merge(lists ...[]PeerEndpoint) []PeerEndpoint {
+	best := map[string]PeerEndpoint{}
+	for _, list := range lists {
+		for _, p := range list {
+			current, ok := best[p.PeerID]
+			if !ok || p.LastSeen.After(current.LastSeen) {
+				best[p.PeerID] = p
+			}
+		}
+	}
+
+	merged := make([]PeerEndpoint, 0, len(best))
+	for _, p := range best {
+		merged = append(merged, p)
+	}
+	return merged
+}
+
+// Aggregator runs a fixed set of backends and exposes their merged view.
+type Aggregator struct {
+	backends []Backend
+}
+
+func NewAggregator(backends ...Backend) *Aggregator {
+	return &Aggregator{backends: backends}
+}
+
+// Announce publishes endpoint to every backend, returning the first error
+// encountered (if any) after trying all of them.
+func (a *Aggregator) Announce(endpoint *net.UDPAddr) error {
+	var firstErr error
+	for _, b := range a.backends {
+		if err := b.Announce(endpoint); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// GetPeers merges the peer lists of every backend that answered
+// successfully; a single backend erroring out (e.g. the arbitr being
+// down) does not prevent the others from contributing.
+func (a *Aggregator) GetPeers() []PeerEndpoint {
+	lists := make([][]PeerEndpoint, 0, len(a.backends))
+	for _, b := range a.backends {
+		peers, err := b.GetPeers()
+		if err != nil {
+			continue
+		}
+		lists = append(lists, peers)
+	}
+	return Merge(lists...)
+}
+
+func (a *Aggregator) Close() error {
+	var firstErr error
+	for _, b := range a.backends {
+		if err := b.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
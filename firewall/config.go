@@ -0,0 +1,89 @@
+package firewall
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"regexp"
+
+	"github.com/xaionaro-go/errors"
+)
+
+// jsonRule is the on-disk shape of one DirectedRule entry in a rules file.
+type jsonRule struct {
+	CIDR          string `json:"cidr"`
+	Direction     string `json:"direction"` // "egress" or "ingress"
+	Proto         string `json:"proto,omitempty"`
+	Port          uint16 `json:"port,omitempty"`
+	PeerNameRegex string `json:"peer_name_regex,omitempty"`
+	Allow         bool   `json:"allow"`
+}
+
+type jsonRulesFile struct {
+	Rules       []jsonRule `json:"rules"`
+	RemoteAllow []string   `json:"remote_allow,omitempty"`
+}
+
+// ReadRulesFile loads a firewall rule set and remote-allow list from a JSON
+// file, in the shape both NewTable and RuleLoader expect -- the
+// daemon reads it once to build the initial Table for vpn.OptSetFirewallTable,
+// and again on every SIGHUP via WatchSIGHUP.
+func ReadRulesFile(path string) ([]DirectedRule, []*net.IPNet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	var cfg jsonRulesFile
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	rules := make([]DirectedRule, 0, len(cfg.Rules))
+	for _, jr := range cfg.Rules {
+		_, cidr, err := net.ParseCIDR(jr.CIDR)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "cidr", jr.CIDR)
+		}
+
+		var direction Direction
+		switch jr.Direction {
+		case "egress":
+			direction = Egress
+		case "ingress":
+			direction = Ingress
+		default:
+			return nil, nil, errors.New(`rule direction must be "egress" or "ingress"`, jr.Direction)
+		}
+
+		var peerRegex *regexp.Regexp
+		if jr.PeerNameRegex != "" {
+			peerRegex, err = regexp.Compile(jr.PeerNameRegex)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "peer_name_regex", jr.PeerNameRegex)
+			}
+		}
+
+		rules = append(rules, DirectedRule{
+			Rule: Rule{
+				CIDR:          cidr,
+				Proto:         jr.Proto,
+				Port:          jr.Port,
+				PeerNameRegex: peerRegex,
+				Allow:         jr.Allow,
+			},
+			Direction: direction,
+		})
+	}
+
+	remoteAllow := make([]*net.IPNet, 0, len(cfg.RemoteAllow))
+	for _, cidrStr := range cfg.RemoteAllow {
+		_, cidr, err := net.ParseCIDR(cidrStr)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "remote_allow cidr", cidrStr)
+		}
+		remoteAllow = append(remoteAllow, cidr)
+	}
+
+	return rules, remoteAllow, nil
+}
@@ -0,0 +1,36 @@
+package firewall
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics is how the firewall reports dropped packets; NewPrometheusMetrics
+// is the production implementation, but tests/tools can supply their own.
+type Metrics interface {
+	Dropped(direction, proto, reason string)
+}
+
+type prometheusMetrics struct {
+	dropped *prometheus.CounterVec
+}
+
+// NewPrometheusMetrics registers a "homenet_firewall_dropped_total" counter
+// vector (labeled by direction, proto and reason) on reg and returns a
+// Metrics backed by it.
+func NewPrometheusMetrics(reg prometheus.Registerer) Metrics {
+	m := &prometheusMetrics{
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "homenet_firewall_dropped_total",
+			Help: "Number of frames dropped by the homenet firewall.",
+		}, []string{"direction", "proto", "reason"}),
+	}
+	reg.MustRegister(m.dropped)
+	return m
+}
+
+func (m *prometheusMetrics) Dropped(direction, proto, reason string) {
+	m.dropped.WithLabelValues(direction, proto, reason).Inc()
+}
+
+// NoopMetrics discards everything, useful when Prometheus export isn't wired up.
+type NoopMetrics struct{}
+
+func (NoopMetrics) Dropped(direction, proto, reason string) {}
@@ -0,0 +1,109 @@
+package firewall
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	ethertypeIPv4 = 0x0800
+	ethertypeIPv6 = 0x86DD
+
+	protoTCP  = 6
+	protoUDP  = 17
+	protoICMP = 1
+)
+
+// Packet is the subset of an IP packet the firewall rules match on,
+// extracted from a TAP ethernet frame.
+type Packet struct {
+	RemoteIP net.IP
+	Proto    string
+	Port     uint16
+}
+
+// ParsePacket pulls RemoteIP/Proto/Port out of an ethernet frame's payload.
+// egress selects whether the "remote" address is the destination (egress,
+// i.e. where the frame is headed) or the source (ingress, i.e. who sent
+// it). Frames that aren't IPv4/IPv6, or are too short to have a full
+// header, come back with a zero Packet and ok == false.
+func ParsePacket(ethertype uint16, payload []byte, egress bool) (Packet, bool) {
+	switch ethertype {
+	case ethertypeIPv4:
+		return parseIPv4(payload, egress)
+	case ethertypeIPv6:
+		return parseIPv6(payload, egress)
+	default:
+		return Packet{}, false
+	}
+}
+
+func parseIPv4(b []byte, egress bool) (Packet, bool) {
+	if len(b) < 20 {
+		return Packet{}, false
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if ihl < 20 || len(b) < ihl {
+		return Packet{}, false
+	}
+	proto := b[9]
+	var remote net.IP
+	if egress {
+		remote = net.IP(b[16:20])
+	} else {
+		remote = net.IP(b[12:16])
+	}
+	pkt := Packet{RemoteIP: remote, Proto: protoName(proto)}
+	if port, ok := l4Port(proto, b[ihl:]); ok {
+		pkt.Port = port
+	}
+	return pkt, true
+}
+
+func parseIPv6(b []byte, egress bool) (Packet, bool) {
+	if len(b) < 40 {
+		return Packet{}, false
+	}
+	proto := b[6]
+	var remote net.IP
+	if egress {
+		remote = net.IP(b[24:40])
+	} else {
+		remote = net.IP(b[8:24])
+	}
+	pkt := Packet{RemoteIP: remote, Proto: protoName(proto)}
+	if port, ok := l4Port(proto, b[40:]); ok {
+		pkt.Port = port
+	}
+	return pkt, true
+}
+
+// l4Port always extracts the destination port, regardless of traffic
+// direction: Packet.Port is matched against Rule.Port as "the service port
+// being reached" (e.g. 22 for SSH) on both egress and ingress, and an
+// ingress packet's *source* port is just the remote peer's ephemeral
+// client port, not something a rule would ever want to filter on.
+func l4Port(proto byte, l4 []byte) (uint16, bool) {
+	if len(l4) < 4 {
+		return 0, false
+	}
+	switch proto {
+	case protoTCP, protoUDP:
+		return binary.BigEndian.Uint16(l4[2:4]), true
+	default:
+		return 0, false
+	}
+}
+
+func protoName(proto byte) string {
+	switch proto {
+	case protoTCP:
+		return "tcp"
+	case protoUDP:
+		return "udp"
+	case protoICMP:
+		return "icmp"
+	default:
+		return ""
+	}
+}
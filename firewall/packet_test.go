@@ -0,0 +1,46 @@
+package firewall
+
+import "testing"
+
+// buildTCPIPv4 builds a minimal IPv4+TCP packet with the given source and
+// destination ports, enough for ParsePacket to extract Proto/Port from.
+func buildTCPIPv4(srcPort, dstPort uint16) []byte {
+	b := make([]byte, 20+20)
+	b[0] = 0x45 // version 4, IHL 5 (20 bytes)
+	b[9] = protoTCP
+	copy(b[12:16], []byte{10, 0, 0, 1}) // source IP
+	copy(b[16:20], []byte{10, 0, 0, 2}) // destination IP
+	b[20] = byte(srcPort >> 8)
+	b[21] = byte(srcPort)
+	b[22] = byte(dstPort >> 8)
+	b[23] = byte(dstPort)
+	return b
+}
+
+// TestParsePacketIngressUsesDestinationPort guards against a prior bug
+// where ingress packets extracted the source (remote peer's ephemeral)
+// port instead of the destination (service) port, making a rule like
+// "allow tcp/22 on ingress" never match a real inbound SSH SYN.
+func TestParsePacketIngressUsesDestinationPort(t *testing.T) {
+	raw := buildTCPIPv4(54321, 22)
+
+	pkt, ok := ParsePacket(ethertypeIPv4, raw, false)
+	if !ok {
+		t.Fatalf("ParsePacket(ingress) returned ok=false")
+	}
+	if pkt.Port != 22 {
+		t.Fatalf("ingress Packet.Port = %d, want 22 (the destination/service port)", pkt.Port)
+	}
+}
+
+func TestParsePacketEgressUsesDestinationPort(t *testing.T) {
+	raw := buildTCPIPv4(54321, 22)
+
+	pkt, ok := ParsePacket(ethertypeIPv4, raw, true)
+	if !ok {
+		t.Fatalf("ParsePacket(egress) returned ok=false")
+	}
+	if pkt.Port != 22 {
+		t.Fatalf("egress Packet.Port = %d, want 22", pkt.Port)
+	}
+}
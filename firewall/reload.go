@@ -0,0 +1,34 @@
+package firewall
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// RuleLoader produces the current rule set and remote allow list, e.g. by
+// re-reading the firewall section of the daemon's config file.
+type RuleLoader func() ([]DirectedRule, []*net.IPNet, error)
+
+// WatchSIGHUP reloads table every time the process receives SIGHUP, using
+// loadRules to read the new rule set. It runs until the process exits and
+// never touches the TAP interface or transport sockets -- only table's
+// rule trees are swapped.
+func WatchSIGHUP(table *Table, loadRules RuleLoader, onError func(error)) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	go func() {
+		for range sighup {
+			rules, remoteAllow, err := loadRules()
+			if err != nil {
+				if onError != nil {
+					onError(err)
+				}
+				continue
+			}
+			table.Reload(rules, remoteAllow)
+		}
+	}()
+}
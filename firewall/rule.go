@@ -0,0 +1,49 @@
+// Package firewall filters TAP frames going in and out of a homenet
+// overlay based on a set of allow/deny CIDR rules, the same shape Nebula
+// uses for its inbound/outbound firewall.
+package firewall
+
+import (
+	"net"
+	"regexp"
+)
+
+// Direction says which side of the vpn a Rule applies to.
+type Direction int
+
+const (
+	// Egress rules are checked against frames read from the TAP device,
+	// before they're handed to vpn.SendToPeer/SendToPeerByIntAlias.
+	Egress Direction = iota
+	// Ingress rules are checked against frames decrypted off the wire,
+	// before they're written into the TAP device.
+	Ingress
+)
+
+// AnyPort means the rule applies regardless of the frame's L4 port.
+const AnyPort = 0
+
+// Rule is one allow/deny entry. An empty Proto matches any protocol, a
+// nil PeerNameRegex matches any peer, and Port == AnyPort matches any port.
+type Rule struct {
+	CIDR          *net.IPNet
+	Proto         string // "tcp", "udp", "icmp", or "" for any
+	Port          uint16
+	PeerNameRegex *regexp.Regexp
+	Allow         bool
+}
+
+func (r *Rule) matchesPeer(peerName string) bool {
+	if r.PeerNameRegex == nil {
+		return true
+	}
+	return r.PeerNameRegex.MatchString(peerName)
+}
+
+func (r *Rule) matchesProto(proto string) bool {
+	return r.Proto == "" || r.Proto == proto
+}
+
+func (r *Rule) matchesPort(port uint16) bool {
+	return r.Port == AnyPort || r.Port == port
+}
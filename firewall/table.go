@@ -0,0 +1,108 @@
+package firewall
+
+import (
+	"net"
+	"sync"
+)
+
+// Table holds the ingress/egress rule trees plus the remote allow list
+// connector consults before dialing a negotiated endpoint, and can be
+// swapped out atomically on reload (e.g. on SIGHUP) without bouncing the
+// TAP interface.
+type Table struct {
+	mu sync.RWMutex
+
+	egress  *cidrTrie
+	ingress *cidrTrie
+
+	remoteAllow *cidrTrie
+
+	metrics Metrics
+}
+
+// NewTable builds a Table from a flat rule set. Rules with Direction ==
+// Egress go in the egress tree, Ingress rules in the ingress tree.
+func NewTable(rules []DirectedRule, remoteAllow []*net.IPNet, metrics Metrics) *Table {
+	t := &Table{
+		egress:      newCIDRTrie(),
+		ingress:     newCIDRTrie(),
+		remoteAllow: newCIDRTrie(),
+		metrics:     metrics,
+	}
+	t.load(rules, remoteAllow)
+	return t
+}
+
+// DirectedRule pairs a Rule with the direction it applies to.
+type DirectedRule struct {
+	Rule
+	Direction Direction
+}
+
+func (t *Table) load(rules []DirectedRule, remoteAllow []*net.IPNet) {
+	egress := newCIDRTrie()
+	ingress := newCIDRTrie()
+	remote := newCIDRTrie()
+
+	for i := range rules {
+		r := rules[i]
+		switch r.Direction {
+		case Egress:
+			egress.insert(&r.Rule)
+		case Ingress:
+			ingress.insert(&r.Rule)
+		}
+	}
+	for _, cidr := range remoteAllow {
+		remote.insert(&Rule{CIDR: cidr, Allow: true})
+	}
+
+	t.mu.Lock()
+	t.egress = egress
+	t.ingress = ingress
+	t.remoteAllow = remote
+	t.mu.Unlock()
+}
+
+// Reload atomically replaces the rule set, to be called from a SIGHUP
+// handler. The TAP interface and transport sockets are left untouched.
+func (t *Table) Reload(rules []DirectedRule, remoteAllow []*net.IPNet) {
+	t.load(rules, remoteAllow)
+}
+
+// AllowEgress decides whether a frame read from the TAP device, destined
+// for peerName, may be sent out.
+func (t *Table) AllowEgress(pkt Packet, peerName string) bool {
+	return t.allow(t.egress, pkt, peerName, "egress")
+}
+
+// AllowIngress decides whether a frame decrypted off the wire, coming
+// from peerName, may be written into the TAP device.
+func (t *Table) AllowIngress(pkt Packet, peerName string) bool {
+	return t.allow(t.ingress, pkt, peerName, "ingress")
+}
+
+// AllowRemote decides whether connector is willing to even dial a
+// negotiated endpoint.
+func (t *Table) AllowRemote(addr net.IP) bool {
+	t.mu.RLock()
+	rule := t.remoteAllow.lookupAny(addr)
+	t.mu.RUnlock()
+	return rule != nil && rule.Allow
+}
+
+func (t *Table) allow(trie *cidrTrie, pkt Packet, peerName, direction string) bool {
+	t.mu.RLock()
+	rule := trie.lookup(pkt.RemoteIP, pkt, peerName)
+	t.mu.RUnlock()
+
+	if rule == nil {
+		t.metrics.Dropped(direction, pkt.Proto, "no-match")
+		return false
+	}
+	if !rule.Allow {
+		t.metrics.Dropped(direction, pkt.Proto, "deny")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package firewall
+
+import "net"
+
+// cidrTrie is a binary trie over the 128 bits of an IPv4-in-IPv6 or native
+// IPv6 address, used for longest-prefix-match rule lookup. IPv4 addresses
+// are stored under their ::ffff:0:0/96-mapped form so both families share
+// one tree.
+type cidrTrie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	rules    []*Rule // every rule whose CIDR ends exactly here, in insertion order
+}
+
+func newCIDRTrie() *cidrTrie {
+	return &cidrTrie{root: &trieNode{}}
+}
+
+func (t *cidrTrie) insert(rule *Rule) {
+	ones, bits := rule.CIDR.Mask.Size()
+	key := to128(rule.CIDR.IP)
+	// bits == 32 means the mask was parsed against a 4-byte IP; shift the
+	// prefix length into the 128-bit address space it's now stored in.
+	if bits == 32 {
+		ones += 96
+	}
+
+	node := t.root
+	for i := 0; i < ones; i++ {
+		bit := keyBit(key, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.rules = append(node.rules, rule)
+}
+
+// matchingPath returns the nodes visited on the way to the longest prefix
+// of ip present in the trie, ordered from the root (least specific) to the
+// deepest match (most specific).
+func (t *cidrTrie) matchingPath(ip net.IP) []*trieNode {
+	key := to128(ip)
+
+	path := make([]*trieNode, 0, 129)
+	node := t.root
+	path = append(path, node)
+	for i := 0; i < 128; i++ {
+		bit := keyBit(key, i)
+		next := node.children[bit]
+		if next == nil {
+			break
+		}
+		node = next
+		path = append(path, node)
+	}
+	return path
+}
+
+// lookup returns the most specific rule matching ip whose proto/port/peer
+// also match pkt and peerName. A CIDR can hold several rules (e.g. one
+// allowing tcp/22 and another allowing udp/53 on the same /24): nodes
+// closer to the leaf are tried first, and within a node rules are tried in
+// insertion order, falling back to shallower (less specific) nodes only
+// when nothing at the current depth matches.
+func (t *cidrTrie) lookup(ip net.IP, pkt Packet, peerName string) *Rule {
+	path := t.matchingPath(ip)
+	for i := len(path) - 1; i >= 0; i-- {
+		for _, rule := range path[i].rules {
+			if rule.matchesProto(pkt.Proto) && rule.matchesPort(pkt.Port) && rule.matchesPeer(peerName) {
+				return rule
+			}
+		}
+	}
+	return nil
+}
+
+// lookupAny returns the first rule stored at the most specific node that
+// has any rules at all, ignoring proto/port/peer -- used by the
+// remote-allow tree, which only ever holds plain CIDR allow entries.
+func (t *cidrTrie) lookupAny(ip net.IP) *Rule {
+	path := t.matchingPath(ip)
+	for i := len(path) - 1; i >= 0; i-- {
+		if len(path[i].rules) > 0 {
+			return path[i].rules[0]
+		}
+	}
+	return nil
+}
+
+func to128(ip net.IP) [16]byte {
+	var out [16]byte
+	v16 := ip.To16()
+	copy(out[:], v16)
+	return out
+}
+
+func keyBit(key [16]byte, i int) int {
+	byteIdx := i / 8
+	bitIdx := 7 - uint(i%8)
+	return int(key[byteIdx]>>bitIdx) & 1
+}
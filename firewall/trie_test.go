@@ -0,0 +1,75 @@
+package firewall
+
+import (
+	"net"
+	"regexp"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("net.ParseCIDR(%q): %s", cidr, err)
+	}
+	return n
+}
+
+// TestCIDRTrieInsertKeepsMultipleRulesPerNode guards against a prior bug
+// where a node held a single *Rule, so a second rule inserted on the same
+// CIDR silently clobbered the first instead of being matched alongside it.
+func TestCIDRTrieInsertKeepsMultipleRulesPerNode(t *testing.T) {
+	trie := newCIDRTrie()
+	cidr := mustParseCIDR(t, "10.0.0.0/24")
+
+	sshRule := &Rule{CIDR: cidr, Proto: "tcp", Port: 22, Allow: true}
+	dnsRule := &Rule{CIDR: cidr, Proto: "udp", Port: 53, Allow: true}
+	trie.insert(sshRule)
+	trie.insert(dnsRule)
+
+	ip := net.ParseIP("10.0.0.5")
+	if got := trie.lookup(ip, Packet{Proto: "tcp", Port: 22}, ""); got != sshRule {
+		t.Fatalf("lookup(tcp/22) = %v, want sshRule", got)
+	}
+	if got := trie.lookup(ip, Packet{Proto: "udp", Port: 53}, ""); got != dnsRule {
+		t.Fatalf("lookup(udp/53) = %v, want dnsRule", got)
+	}
+	if got := trie.lookup(ip, Packet{Proto: "tcp", Port: 443}, ""); got != nil {
+		t.Fatalf("lookup(tcp/443) = %v, want nil (no matching rule)", got)
+	}
+}
+
+// TestCIDRTrieLookupPrefersMostSpecificNode checks that a rule on a /32
+// (or /128) wins over a broader rule covering the same address, and that
+// lookup falls back to the broader node when the specific one has nothing
+// matching the packet's proto/port.
+func TestCIDRTrieLookupPrefersMostSpecificNode(t *testing.T) {
+	trie := newCIDRTrie()
+	broad := &Rule{CIDR: mustParseCIDR(t, "10.0.0.0/24"), Allow: true}
+	narrow := &Rule{CIDR: mustParseCIDR(t, "10.0.0.5/32"), Proto: "tcp", Port: 22, Allow: false}
+	trie.insert(broad)
+	trie.insert(narrow)
+
+	ip := net.ParseIP("10.0.0.5")
+	if got := trie.lookup(ip, Packet{Proto: "tcp", Port: 22}, ""); got != narrow {
+		t.Fatalf("lookup(tcp/22 on 10.0.0.5) = %v, want the /32 rule", got)
+	}
+	// The /32 node has no udp/53 rule, so lookup must fall back to /24.
+	if got := trie.lookup(ip, Packet{Proto: "udp", Port: 53}, ""); got != broad {
+		t.Fatalf("lookup(udp/53 on 10.0.0.5) = %v, want the /24 rule", got)
+	}
+}
+
+func TestCIDRTrieLookupMatchesPeerNameRegex(t *testing.T) {
+	trie := newCIDRTrie()
+	rule := &Rule{CIDR: mustParseCIDR(t, "10.0.0.0/24"), PeerNameRegex: regexp.MustCompile("^laptop-"), Allow: true}
+	trie.insert(rule)
+
+	ip := net.ParseIP("10.0.0.5")
+	if got := trie.lookup(ip, Packet{}, "laptop-alice"); got != rule {
+		t.Fatalf("lookup with matching peer name = %v, want rule", got)
+	}
+	if got := trie.lookup(ip, Packet{}, "server-bob"); got != nil {
+		t.Fatalf("lookup with non-matching peer name = %v, want nil", got)
+	}
+}
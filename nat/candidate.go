@@ -0,0 +1,81 @@
+// Package nat implements STUN-based NAT traversal for homenet peers: it
+// gathers reflexive address candidates for the local datapath socket and
+// performs simultaneous-open hole punching against a remote peer's
+// candidates, so two peers behind NATs can agree on a UDP pair they can
+// both reach without relaying through the arbitr.
+package nat
+
+import "net"
+
+// CandidateType says how a candidate address was learned.
+type CandidateType int
+
+const (
+	// CandidateHost is a local socket address, as already registered by
+	// RegisterPeer before this package existed.
+	CandidateHost CandidateType = iota
+	// CandidateServerReflexive is what a STUN server saw as our source
+	// address, i.e. our NAT's public mapping.
+	CandidateServerReflexive
+	// CandidateRelay is the configured TURN relay's address, which
+	// Puncher.GatherCandidates lists as a local candidate only when no
+	// STUN server answered. No TURN allocation is made for it.
+	CandidateRelay
+	// CandidateCalculated is a "best guess" address synthesized from the
+	// peer's overlay address and a configured underlay mask, see
+	// connector.CalculatedRemoteRule.
+	CandidateCalculated
+)
+
+func (t CandidateType) String() string {
+	switch t {
+	case CandidateHost:
+		return "host"
+	case CandidateServerReflexive:
+		return "srflx"
+	case CandidateRelay:
+		return "relay"
+	case CandidateCalculated:
+		return "calculated"
+	default:
+		return "unknown"
+	}
+}
+
+// Candidate is one address/port a peer might be reachable at.
+type Candidate struct {
+	Type CandidateType
+	Addr *net.UDPAddr
+}
+
+// PairState tracks a candidate pair through the hole-punching state machine.
+type PairState int
+
+const (
+	PairWaiting PairState = iota
+	PairInProgress
+	PairSucceeded
+	PairFailed
+)
+
+func (s PairState) String() string {
+	switch s {
+	case PairWaiting:
+		return "waiting"
+	case PairInProgress:
+		return "in-progress"
+	case PairSucceeded:
+		return "succeeded"
+	case PairFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// CandidatePair is a (local, remote) candidate combination being probed.
+type CandidatePair struct {
+	Local  Candidate
+	Remote Candidate
+	State  PairState
+}
@@ -0,0 +1,226 @@
+package nat
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xaionaro-go/errors"
+)
+
+// Hooks lets a caller observe the traversal process without depending on
+// the nat package's internals -- see connector.Logger.OnCandidateGathered
+// and OnPairStateChanged, which this package drives.
+type Hooks interface {
+	OnCandidateGathered(peerID string, candidate Candidate)
+	OnPairStateChanged(peerID string, pair CandidatePair)
+}
+
+// Options configures a Puncher.
+type Options struct {
+	StunServers []*net.UDPAddr
+	TurnRelay   *net.UDPAddr // optional, see CandidateRelay; Punch does not retry through it after the pairs fail
+	PingPeriod  time.Duration
+	Timeout     time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.PingPeriod == 0 {
+		o.PingPeriod = 200 * time.Millisecond
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 5 * time.Second
+	}
+}
+
+// Puncher gathers local candidates and punches holes against a remote
+// peer's candidate list.
+//
+// Punch owns conn for the whole race: it runs a single read loop that
+// demuxes every incoming STUN message by transaction ID, so conn must not
+// be shared with unrelated traffic (e.g. the encrypted datapath socket)
+// while a Punch is in progress, or datagrams would be read by whichever
+// goroutine happens to call ReadFromUDP first.
+type Puncher struct {
+	conn  *net.UDPConn
+	opts  Options
+	hooks Hooks
+}
+
+func NewPuncher(conn *net.UDPConn, opts Options, hooks Hooks) *Puncher {
+	opts.setDefaults()
+	return &Puncher{conn: conn, opts: opts, hooks: hooks}
+}
+
+// GatherCandidates returns the host candidate (the socket's local address)
+// plus one server-reflexive candidate per configured STUN server.
+func (p *Puncher) GatherCandidates(peerID string, hostAddr *net.UDPAddr) ([]Candidate, error) {
+	candidates := []Candidate{{Type: CandidateHost, Addr: hostAddr}}
+	p.hooks.OnCandidateGathered(peerID, candidates[0])
+
+	for _, server := range p.opts.StunServers {
+		reflexive, err := QueryReflexiveAddr(p.conn, server)
+		if err != nil {
+			continue
+		}
+		candidate := Candidate{Type: CandidateServerReflexive, Addr: reflexive}
+		candidates = append(candidates, candidate)
+		p.hooks.OnCandidateGathered(peerID, candidate)
+	}
+
+	if len(candidates) == 1 && p.opts.TurnRelay != nil {
+		candidates = append(candidates, Candidate{Type: CandidateRelay, Addr: p.opts.TurnRelay})
+	}
+
+	return candidates, nil
+}
+
+// pendingProbe is a Binding Request this side sent out, waiting to be
+// confirmed by a matching Binding Success Response.
+type pendingProbe struct {
+	pair *CandidatePair
+	done chan struct{}
+}
+
+// Punch races every (local, remote) candidate pair in parallel, sending
+// periodic STUN Binding Requests and replying to the peer's own requests,
+// and returns the first pair that actually round-trips: a request we sent
+// on it was answered by the other side, which is the only way to know a
+// pair is reachable in both directions (simultaneous-open hole punching). It
+// blocks until a pair succeeds or opts.Timeout elapses.
+func (p *Puncher) Punch(peerID string, local, remote []Candidate) (*CandidatePair, error) {
+	pairs := make([]*CandidatePair, 0, len(local)*len(remote))
+	for _, l := range local {
+		for _, r := range remote {
+			pair := &CandidatePair{Local: l, Remote: r, State: PairWaiting}
+			pairs = append(pairs, pair)
+		}
+	}
+
+	winner := make(chan *CandidatePair, 1)
+	stop := make(chan struct{})
+
+	pendingMu := sync.Mutex{}
+	pending := map[[12]byte]*pendingProbe{}
+
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		p.demuxLoop(stop, &pendingMu, pending)
+	}()
+	defer func() {
+		close(stop)
+		<-readDone
+	}()
+
+	for _, pair := range pairs {
+		go p.probePair(peerID, pair, &pendingMu, pending, winner, stop)
+	}
+
+	select {
+	case pair := <-winner:
+		return pair, nil
+	case <-time.After(p.opts.Timeout):
+		return nil, errors.New("hole punching timed out: no candidate pair round-tripped")
+	}
+}
+
+// demuxLoop reads every datagram on conn until stop fires, answering
+// incoming Binding Requests and waking up the matching probePair for
+// Binding Success Responses.
+func (p *Puncher) demuxLoop(stop <-chan struct{}, pendingMu *sync.Mutex, pending map[[12]byte]*pendingProbe) {
+	buf := make([]byte, 1500)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if err := p.conn.SetReadDeadline(time.Now().Add(p.opts.PingPeriod)); err != nil {
+			return
+		}
+		n, from, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			continue // deadline hit or transient error; re-check stop above
+		}
+		msg := buf[:n]
+
+		if txID, ok := parseBindingRequestTxID(msg); ok {
+			resp := buildBindingSuccessResponse(txID, from)
+			p.conn.WriteToUDP(resp, from)
+			continue
+		}
+
+		if txID, ok := parseBindingSuccessTxID(msg); ok {
+			pendingMu.Lock()
+			probe := pending[txID]
+			pendingMu.Unlock()
+			if probe != nil {
+				select {
+				case <-probe.done:
+				default:
+					close(probe.done)
+				}
+			}
+		}
+	}
+}
+
+func (p *Puncher) probePair(peerID string, pair *CandidatePair, pendingMu *sync.Mutex, pending map[[12]byte]*pendingProbe, winner chan<- *CandidatePair, stop <-chan struct{}) {
+	pair.State = PairInProgress
+	p.hooks.OnPairStateChanged(peerID, *pair)
+
+	ticker := time.NewTicker(p.opts.PingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			req, txID, err := buildBindingRequest()
+			if err != nil {
+				continue
+			}
+			done := make(chan struct{})
+			probe := &pendingProbe{pair: pair, done: done}
+
+			pendingMu.Lock()
+			pending[txID] = probe
+			pendingMu.Unlock()
+
+			if _, err := p.conn.WriteToUDP(req, pair.Remote.Addr); err != nil {
+				pendingMu.Lock()
+				delete(pending, txID)
+				pendingMu.Unlock()
+				continue
+			}
+
+			select {
+			case <-done:
+				pendingMu.Lock()
+				delete(pending, txID)
+				pendingMu.Unlock()
+
+				pair.State = PairSucceeded
+				p.hooks.OnPairStateChanged(peerID, *pair)
+				select {
+				case winner <- pair:
+				default:
+				}
+				return
+			case <-stop:
+				pendingMu.Lock()
+				delete(pending, txID)
+				pendingMu.Unlock()
+				return
+			case <-time.After(p.opts.PingPeriod):
+				pendingMu.Lock()
+				delete(pending, txID)
+				pendingMu.Unlock()
+				// fall through to the next ticker fire with a fresh request
+			}
+		}
+	}
+}
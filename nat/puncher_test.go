@@ -0,0 +1,63 @@
+package nat
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type noopHooks struct{}
+
+func (noopHooks) OnCandidateGathered(peerID string, candidate Candidate) {}
+func (noopHooks) OnPairStateChanged(peerID string, pair CandidatePair)   {}
+
+func mustListenUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("net.ListenUDP: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestPunchConfirmsRoundTrip reproduces the scenario the review comment
+// called out: probePair must not declare PairSucceeded just because it
+// sent a Binding Request -- it needs a real Binding Success Response back.
+// Two real loopback sockets punch against each other's host candidate and
+// must both converge on the single pair that actually round-trips.
+func TestPunchConfirmsRoundTrip(t *testing.T) {
+	connA := mustListenUDP(t)
+	connB := mustListenUDP(t)
+
+	opts := Options{PingPeriod: 20 * time.Millisecond, Timeout: 2 * time.Second}
+	puncherA := NewPuncher(connA, opts, noopHooks{})
+	puncherB := NewPuncher(connB, opts, noopHooks{})
+
+	localA := []Candidate{{Type: CandidateHost, Addr: connA.LocalAddr().(*net.UDPAddr)}}
+	localB := []Candidate{{Type: CandidateHost, Addr: connB.LocalAddr().(*net.UDPAddr)}}
+
+	resultA := make(chan *CandidatePair, 1)
+	errA := make(chan error, 1)
+	go func() {
+		pair, err := puncherA.Punch("peerB", localA, localB)
+		resultA <- pair
+		errA <- err
+	}()
+
+	pairB, errB := puncherB.Punch("peerA", localB, localA)
+	if errB != nil {
+		t.Fatalf("puncherB.Punch: %s", errB)
+	}
+	if pairB.State != PairSucceeded {
+		t.Fatalf("puncherB's winning pair state = %v, want PairSucceeded", pairB.State)
+	}
+
+	pairA := <-resultA
+	if err := <-errA; err != nil {
+		t.Fatalf("puncherA.Punch: %s", err)
+	}
+	if pairA.State != PairSucceeded {
+		t.Fatalf("puncherA's winning pair state = %v, want PairSucceeded", pairA.State)
+	}
+}
@@ -0,0 +1,240 @@
+package nat
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/xaionaro-go/errors"
+)
+
+const (
+	stunMagicCookie   = 0x2112A442
+	stunBindingReqest = 0x0001
+	stunBindingSucces = 0x0101
+
+	attrXORMappedAddress = 0x0020
+	attrMappedAddress    = 0x0001
+
+	stunTimeout = 500 * time.Millisecond
+)
+
+var ErrNoStunResponse = errors.New("no usable response from the STUN server")
+
+// QueryReflexiveAddr sends an RFC 5389 Binding Request over conn to server
+// and returns the XOR-MAPPED-ADDRESS (falling back to MAPPED-ADDRESS) the
+// server observed, i.e. our NAT's public mapping for this socket.
+func QueryReflexiveAddr(conn *net.UDPConn, server *net.UDPAddr) (*net.UDPAddr, error) {
+	var txID [12]byte
+	if _, err := rand.Read(txID[:]); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingReqest)
+	binary.BigEndian.PutUint16(req[2:4], 0) // message length, no attributes
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	copy(req[8:20], txID[:])
+
+	if err := conn.SetReadDeadline(time.Now().Add(stunTimeout)); err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	if _, err := conn.WriteToUDP(req, server); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, errors.Wrap(ErrNoStunResponse, err)
+		}
+		if from.IP.Equal(server.IP) && from.Port == server.Port {
+			return parseBindingResponse(buf[:n], txID)
+		}
+		// Not our STUN reply (could be a datapath datagram racing us on the
+		// same socket) -- keep waiting until the deadline.
+	}
+}
+
+func parseBindingResponse(msg []byte, wantTxID [12]byte) (*net.UDPAddr, error) {
+	if len(msg) < 20 {
+		return nil, errors.Wrap(ErrNoStunResponse, "message too short")
+	}
+	msgType := binary.BigEndian.Uint16(msg[0:2])
+	msgLen := binary.BigEndian.Uint16(msg[2:4])
+	if msgType != stunBindingSucces {
+		return nil, errors.Wrap(ErrNoStunResponse, "unexpected message type", msgType)
+	}
+	if !equalTxID(msg[8:20], wantTxID) {
+		return nil, errors.Wrap(ErrNoStunResponse, "transaction ID mismatch")
+	}
+
+	attrs := msg[20:]
+	if int(msgLen) < len(attrs) {
+		attrs = attrs[:msgLen]
+	}
+
+	var mapped *net.UDPAddr
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := binary.BigEndian.Uint16(attrs[2:4])
+		if int(attrLen)+4 > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+attrLen]
+
+		switch attrType {
+		case attrXORMappedAddress:
+			if addr, err := decodeXORMappedAddress(value); err == nil {
+				mapped = addr
+			}
+		case attrMappedAddress:
+			if addr, err := decodeMappedAddress(value); err == nil && mapped == nil {
+				mapped = addr
+			}
+		}
+
+		// attributes are padded to a 4-byte boundary
+		advance := 4 + int(attrLen)
+		if pad := advance % 4; pad != 0 {
+			advance += 4 - pad
+		}
+		if advance > len(attrs) {
+			break
+		}
+		attrs = attrs[advance:]
+	}
+
+	if mapped == nil {
+		return nil, errors.Wrap(ErrNoStunResponse, "no (XOR-)MAPPED-ADDRESS attribute")
+	}
+	return mapped, nil
+}
+
+func decodeMappedAddress(value []byte) (*net.UDPAddr, error) {
+	if len(value) < 8 || value[1] != 0x01 {
+		return nil, errors.New("not an IPv4 MAPPED-ADDRESS")
+	}
+	port := binary.BigEndian.Uint16(value[2:4])
+	ip := net.IP(value[4:8])
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func decodeXORMappedAddress(value []byte) (*net.UDPAddr, error) {
+	if len(value) < 8 || value[1] != 0x01 {
+		return nil, errors.New("not an IPv4 XOR-MAPPED-ADDRESS")
+	}
+	xport := binary.BigEndian.Uint16(value[2:4])
+	port := xport ^ uint16(stunMagicCookie>>16)
+
+	var cookie [4]byte
+	binary.BigEndian.PutUint32(cookie[:], stunMagicCookie)
+
+	ip := make(net.IP, 4)
+	for i := 0; i < 4; i++ {
+		ip[i] = value[4+i] ^ cookie[i]
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func equalTxID(got []byte, want [12]byte) bool {
+	if len(got) != 12 {
+		return false
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// buildBindingRequest builds an RFC 5389 Binding Request (with no
+// attributes) and the random transaction ID it carries, for use by
+// code that needs to match its own response out of a shared socket --
+// see Puncher.probePair, which sends these directly to a candidate
+// peer address instead of a STUN server.
+func buildBindingRequest() ([]byte, [12]byte, error) {
+	var txID [12]byte
+	if _, err := rand.Read(txID[:]); err != nil {
+		return nil, txID, errors.Wrap(err)
+	}
+
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingReqest)
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	copy(req[8:20], txID[:])
+	return req, txID, nil
+}
+
+// buildBindingSuccessResponse answers a Binding Request with txID,
+// reporting observed (typically the UDPAddr the request was actually read
+// from) as the XOR-MAPPED-ADDRESS -- the other half of the round-trip a
+// peer needs to confirm a candidate pair actually works in both
+// directions.
+func buildBindingSuccessResponse(txID [12]byte, observed *net.UDPAddr) []byte {
+	ip4 := observed.IP.To4()
+	if ip4 == nil {
+		// Only IPv4 XOR-MAPPED-ADDRESS is implemented, matching the rest of
+		// this package's decoders.
+		ip4 = net.IPv4(0, 0, 0, 0).To4()
+	}
+
+	msg := make([]byte, 32)
+	binary.BigEndian.PutUint16(msg[0:2], stunBindingSucces)
+	binary.BigEndian.PutUint16(msg[2:4], 12) // one XOR-MAPPED-ADDRESS attribute, value 8 bytes + 4 byte header
+	binary.BigEndian.PutUint32(msg[4:8], stunMagicCookie)
+	copy(msg[8:20], txID[:])
+
+	binary.BigEndian.PutUint16(msg[20:22], attrXORMappedAddress)
+	binary.BigEndian.PutUint16(msg[22:24], 8)
+	msg[25] = 0x01 // family: IPv4
+	xport := uint16(observed.Port) ^ uint16(stunMagicCookie>>16)
+	binary.BigEndian.PutUint16(msg[26:28], xport)
+
+	var cookie [4]byte
+	binary.BigEndian.PutUint32(cookie[:], stunMagicCookie)
+	for i := 0; i < 4; i++ {
+		msg[28+i] = ip4[i] ^ cookie[i]
+	}
+	return msg
+}
+
+// parseBindingRequestTxID reports whether msg is a Binding Request and, if
+// so, its transaction ID.
+func parseBindingRequestTxID(msg []byte) ([12]byte, bool) {
+	var txID [12]byte
+	if len(msg) < 20 {
+		return txID, false
+	}
+	if binary.BigEndian.Uint16(msg[0:2]) != stunBindingReqest {
+		return txID, false
+	}
+	if binary.BigEndian.Uint32(msg[4:8]) != stunMagicCookie {
+		return txID, false
+	}
+	copy(txID[:], msg[8:20])
+	return txID, true
+}
+
+// parseBindingSuccessTxID reports whether msg is a Binding Success
+// Response and, if so, its transaction ID. It does not decode the
+// attributes -- the puncher only needs the round-trip confirmation.
+func parseBindingSuccessTxID(msg []byte) ([12]byte, bool) {
+	var txID [12]byte
+	if len(msg) < 20 {
+		return txID, false
+	}
+	if binary.BigEndian.Uint16(msg[0:2]) != stunBindingSucces {
+		return txID, false
+	}
+	if binary.BigEndian.Uint32(msg[4:8]) != stunMagicCookie {
+		return txID, false
+	}
+	copy(txID[:], msg[8:20])
+	return txID, true
+}
@@ -0,0 +1,85 @@
+package nat
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBuildAndParseBindingRequestTxID(t *testing.T) {
+	req, txID, err := buildBindingRequest()
+	if err != nil {
+		t.Fatalf("buildBindingRequest: %s", err)
+	}
+
+	gotTxID, ok := parseBindingRequestTxID(req)
+	if !ok {
+		t.Fatalf("parseBindingRequestTxID(req) = false, want true")
+	}
+	if gotTxID != txID {
+		t.Fatalf("parseBindingRequestTxID(req) = %x, want %x", gotTxID, txID)
+	}
+
+	if _, ok := parseBindingSuccessTxID(req); ok {
+		t.Fatalf("parseBindingSuccessTxID(a binding request) = true, want false")
+	}
+}
+
+func TestBuildAndParseBindingSuccessTxID(t *testing.T) {
+	_, txID, err := buildBindingRequest()
+	if err != nil {
+		t.Fatalf("buildBindingRequest: %s", err)
+	}
+
+	observed := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 4242}
+	resp := buildBindingSuccessResponse(txID, observed)
+
+	gotTxID, ok := parseBindingSuccessTxID(resp)
+	if !ok {
+		t.Fatalf("parseBindingSuccessTxID(resp) = false, want true")
+	}
+	if gotTxID != txID {
+		t.Fatalf("parseBindingSuccessTxID(resp) = %x, want %x", gotTxID, txID)
+	}
+
+	if _, ok := parseBindingRequestTxID(resp); ok {
+		t.Fatalf("parseBindingRequestTxID(a binding success response) = true, want false")
+	}
+}
+
+// TestBuildBindingSuccessResponseRoundTripsThroughParseBindingResponse
+// checks buildBindingSuccessResponse and parseBindingResponse agree on the
+// wire format -- the same response a probePair peer builds must decode
+// back to the observed address via the path QueryReflexiveAddr uses.
+func TestBuildBindingSuccessResponseRoundTripsThroughParseBindingResponse(t *testing.T) {
+	_, txID, err := buildBindingRequest()
+	if err != nil {
+		t.Fatalf("buildBindingRequest: %s", err)
+	}
+
+	observed := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 23), Port: 51820}
+	resp := buildBindingSuccessResponse(txID, observed)
+
+	got, err := parseBindingResponse(resp, txID)
+	if err != nil {
+		t.Fatalf("parseBindingResponse: %s", err)
+	}
+	if !got.IP.Equal(observed.IP) || got.Port != observed.Port {
+		t.Fatalf("parseBindingResponse = %s, want %s", got, observed)
+	}
+}
+
+func TestParseBindingResponseRejectsMismatchedTxID(t *testing.T) {
+	_, txID, err := buildBindingRequest()
+	if err != nil {
+		t.Fatalf("buildBindingRequest: %s", err)
+	}
+	_, otherTxID, err := buildBindingRequest()
+	if err != nil {
+		t.Fatalf("buildBindingRequest: %s", err)
+	}
+
+	resp := buildBindingSuccessResponse(txID, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 23), Port: 1})
+	if _, err := parseBindingResponse(resp, otherTxID); err == nil {
+		t.Fatalf("parseBindingResponse with the wrong expected txID returned no error")
+	}
+}
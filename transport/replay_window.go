@@ -0,0 +1,70 @@
+package transport
+
+// replayWindow is a sliding-window replay filter over a monotonic nonce
+// counter, the same scheme used by IPsec/WireGuard: we remember which of
+// the last replayWindowSize counters we've already seen as a bitmap
+// anchored at the highest counter observed so far.
+type replayWindow struct {
+	highest uint64
+	seen    [replayWindowSize / 64]uint64
+	init    bool
+}
+
+// accept reports whether counter is new (and marks it as seen). A false
+// return means the datagram must be dropped as a replay.
+func (w *replayWindow) accept(counter uint64) bool {
+	if !w.init {
+		w.init = true
+		w.highest = counter
+		w.mark(counter)
+		return true
+	}
+
+	if counter > w.highest {
+		shift := counter - w.highest
+		w.advance(shift)
+		w.highest = counter
+		w.mark(counter)
+		return true
+	}
+
+	diff := w.highest - counter
+	if diff >= replayWindowSize {
+		return false
+	}
+	if w.isMarked(counter) {
+		return false
+	}
+	w.mark(counter)
+	return true
+}
+
+// reject undoes a provisional mark, used when authentication fails after
+// the counter was accepted by the window check.
+func (w *replayWindow) reject(counter uint64) {
+	bit := counter % replayWindowSize
+	w.seen[bit/64] &^= 1 << (bit % 64)
+}
+
+func (w *replayWindow) mark(counter uint64) {
+	bit := counter % replayWindowSize
+	w.seen[bit/64] |= 1 << (bit % 64)
+}
+
+func (w *replayWindow) isMarked(counter uint64) bool {
+	bit := counter % replayWindowSize
+	return w.seen[bit/64]&(1<<(bit%64)) != 0
+}
+
+// advance slides the window forward by shift slots, clearing the bits that
+// fall out of range.
+func (w *replayWindow) advance(shift uint64) {
+	if shift >= replayWindowSize {
+		w.seen = [replayWindowSize / 64]uint64{}
+		return
+	}
+	for i := w.highest + 1; i <= w.highest+shift; i++ {
+		bit := i % replayWindowSize
+		w.seen[bit/64] &^= 1 << (bit % 64)
+	}
+}
@@ -0,0 +1,409 @@
+// Package transport implements the encrypted UDP datapath used to carry
+// TAP frames between homenet peers. It opens a single UDP socket, derives
+// a per-peer, per-direction session key via Curve25519 ECDH on top of the
+// peers' identity keys, and authenticates every datagram with
+// ChaCha20-Poly1305.
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+
+	"crypto/sha256"
+
+	"github.com/xaionaro-go/errors"
+)
+
+const (
+	// ProtocolVersion is the first byte of every datagram we send.
+	ProtocolVersion = 1
+
+	// headerSize is: version(1) + src peer int alias(4) + epoch(4) + nonce counter(8).
+	headerSize = 1 + 4 + 4 + 8
+
+	replayWindowSize = 1024
+
+	// RekeyAfterBytes forces a fresh key derivation once a session has
+	// carried this many bytes of plaintext in the current epoch.
+	RekeyAfterBytes = 1 << 30 // 1GiB
+
+	// RekeyAfterDuration forces a fresh key derivation once an epoch is
+	// this old, even if RekeyAfterBytes hasn't been hit.
+	RekeyAfterDuration = 10 * time.Minute
+
+	labelInitiatorToResponder = "homenet-peer transport v2 initiator->responder"
+	labelResponderToInitiator = "homenet-peer transport v2 responder->initiator"
+)
+
+var (
+	ErrUnknownPeer   = errors.NotFound.New("unknown peer")
+	ErrNoEndpoint    = errors.New("peer has no known endpoint yet")
+	ErrShortDatagram = errors.New("datagram is shorter than the header")
+	ErrBadVersion    = errors.New("unsupported protocol version")
+	ErrReplayed      = errors.New("nonce was already seen (replay) or the epoch went backwards")
+	ErrDecryptFailed = errors.New("unable to decrypt/authenticate datagram")
+)
+
+// Logger matches the logger shape already used by the vpn package.
+type Logger interface {
+	Printf(fmt string, args ...interface{})
+	Print(args ...interface{})
+}
+
+// FrameHandler is called with a decrypted frame received from srcPeerIntAlias.
+type FrameHandler func(srcPeerIntAlias uint32, frame []byte)
+
+// PeerKeys is the subset of a peer's identity we need to run ECDH.
+type PeerKeys struct {
+	Public  [32]byte
+	Private [32]byte
+}
+
+// Transport owns the UDP socket and the set of per-peer sessions.
+type Transport struct {
+	conn     *net.UDPConn
+	ourKeys  PeerKeys
+	ourAlias uint32
+	onFrame  FrameHandler
+	logger   Logger
+
+	locker   sync.Mutex
+	sessions map[uint32]*session
+
+	closeChan chan struct{}
+}
+
+// New opens the UDP socket on listenAddr (":<port>" to bind all interfaces)
+// and starts the receive loop. onFrame is invoked (from the receive
+// goroutine) for every successfully decrypted frame.
+func New(listenAddr string, ourAlias uint32, ourKeys PeerKeys, onFrame FrameHandler, logger Logger) (*Transport, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	t := &Transport{
+		conn:      conn,
+		ourKeys:   ourKeys,
+		ourAlias:  ourAlias,
+		onFrame:   onFrame,
+		logger:    logger,
+		sessions:  map[uint32]*session{},
+		closeChan: make(chan struct{}),
+	}
+
+	go t.readLoop()
+	return t, nil
+}
+
+func (t *Transport) Close() error {
+	close(t.closeChan)
+	return t.conn.Close()
+}
+
+// SetPeerEndpoint records (or updates) the UDP endpoint and public key we
+// should use to reach peerIntAlias. It does not perform the ECDH exchange
+// itself -- that happens lazily on first Send/receive, and again on rekey.
+func (t *Transport) SetPeerEndpoint(peerIntAlias uint32, endpoint *net.UDPAddr, peerPublic [32]byte) {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+
+	sess := t.sessions[peerIntAlias]
+	if sess == nil {
+		sess = &session{}
+		t.sessions[peerIntAlias] = sess
+	}
+	sess.endpoint = endpoint
+	sess.peerPublic = peerPublic
+}
+
+// Send encrypts frame and writes it to the peer's current endpoint.
+func (t *Transport) Send(peerIntAlias uint32, frame []byte) error {
+	t.locker.Lock()
+	sess := t.sessions[peerIntAlias]
+	t.locker.Unlock()
+	if sess == nil {
+		return errors.Wrap(ErrUnknownPeer, "integer alias", peerIntAlias)
+	}
+
+	sess.locker.Lock()
+	defer sess.locker.Unlock()
+
+	if sess.endpoint == nil {
+		return errors.Wrap(ErrNoEndpoint, "integer alias", peerIntAlias)
+	}
+
+	if sess.sendAEAD == nil {
+		if err := sess.deriveSendLocked(t.ourKeys, sess.sendEpoch); err != nil {
+			return errors.Wrap(err)
+		}
+	} else if sess.needsRekeyLocked() {
+		// Always move to a newer epoch: re-deriving at the current one would
+		// give back the same key with its counter reset to 0.
+		if err := sess.deriveSendLocked(t.ourKeys, sess.sendEpoch+1); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	datagram, err := sess.sealLocked(t.ourAlias, frame)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if _, err := t.conn.WriteToUDP(datagram, sess.endpoint); err != nil {
+		return errors.Wrap(err)
+	}
+	return nil
+}
+
+func (t *Transport) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		select {
+		case <-t.closeChan:
+			return
+		default:
+		}
+
+		n, addr, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-t.closeChan:
+				return
+			default:
+			}
+			t.logger.Printf("transport: read error: %s", err)
+			continue
+		}
+
+		if err := t.handleDatagram(buf[:n], addr); err != nil {
+			t.logger.Printf("transport: dropping datagram from %s: %s", addr, err)
+		}
+	}
+}
+
+func (t *Transport) handleDatagram(datagram []byte, from *net.UDPAddr) error {
+	if len(datagram) < headerSize {
+		return ErrShortDatagram
+	}
+	if datagram[0] != ProtocolVersion {
+		return errors.Wrap(ErrBadVersion, "version", datagram[0])
+	}
+	srcAlias := binary.BigEndian.Uint32(datagram[1:5])
+	epoch := binary.BigEndian.Uint32(datagram[5:9])
+	nonceCounter := binary.BigEndian.Uint64(datagram[9:headerSize])
+
+	t.locker.Lock()
+	sess := t.sessions[srcAlias]
+	t.locker.Unlock()
+	if sess == nil {
+		return errors.Wrap(ErrUnknownPeer, "integer alias", srcAlias)
+	}
+
+	sess.locker.Lock()
+	defer sess.locker.Unlock()
+
+	if sess.recvAEAD != nil && epoch == sess.recvEpoch {
+		if !sess.recvWindow.accept(nonceCounter) {
+			return errors.Wrap(ErrReplayed, "counter", nonceCounter)
+		}
+		frame, err := sess.openLocked(sess.recvAEAD, datagram)
+		if err != nil {
+			sess.recvWindow.reject(nonceCounter)
+			return errors.Wrap(ErrDecryptFailed, err)
+		}
+		sess.endpoint = from
+		t.onFrame(srcAlias, frame)
+		return nil
+	}
+
+	// A lower epoch than the one we're already on would mean either a
+	// replay of a pre-rekey datagram or a peer that reset its state;
+	// either way we must not resurrect an old key under a counter that's
+	// already been used.
+	if sess.recvAEAD != nil && epoch < sess.recvEpoch {
+		return errors.Wrap(ErrReplayed, "epoch", epoch, "current epoch", sess.recvEpoch)
+	}
+
+	// epoch is unauthenticated plaintext at this point -- nothing has
+	// verified yet that this datagram is actually from the peer. Derive
+	// into a candidate key/window and a candidate replay window, and only
+	// commit them to sess once the datagram authenticates under the
+	// candidate key. Committing before that would let an off-path
+	// attacker spoof a single datagram with an inflated epoch and
+	// permanently desync us: recvEpoch would advance on a datagram that
+	// never decrypts, and every subsequent *legitimate* datagram (at the
+	// real, lower epoch) would then look like a replay and be dropped.
+	candidateAEAD, err := sess.deriveRecvKeyLocked(t.ourKeys, epoch)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	var candidateWindow replayWindow
+	if !candidateWindow.accept(nonceCounter) {
+		return errors.Wrap(ErrReplayed, "counter", nonceCounter)
+	}
+
+	frame, err := sess.openLocked(candidateAEAD, datagram)
+	if err != nil {
+		return errors.Wrap(ErrDecryptFailed, err)
+	}
+
+	sess.recvAEAD = candidateAEAD
+	sess.recvEpoch = epoch
+	sess.recvWindow = candidateWindow
+	sess.endpoint = from
+
+	t.onFrame(srcAlias, frame)
+	return nil
+}
+
+// session is the per-peer crypto + replay state. All fields are protected
+// by locker.
+//
+// The two directions of a session use independently-derived keys (see
+// deriveSendLocked/deriveRecvLocked): the ECDH output between two peers'
+// static identity keys is symmetric, so without a direction label both
+// peers would encrypt their very first datagram (nonce/counter 0) under
+// the exact same key -- a full ChaCha20-Poly1305 break. Rekeying advances
+// an epoch that's folded into key derivation (and carried in the wire
+// header) so a "rekey" actually produces new key material instead of
+// re-deriving the same static key and resetting the counter under it.
+type session struct {
+	locker sync.Mutex
+
+	endpoint   *net.UDPAddr
+	peerPublic [32]byte
+
+	sendEpoch     uint32
+	sendAEAD      aeadCipher
+	sendCounter   uint64
+	bytesSent     uint64
+	establishedAt time.Time
+
+	recvEpoch  uint32
+	recvAEAD   aeadCipher
+	recvWindow replayWindow
+}
+
+// aeadCipher is narrowed down to the two methods we use, so tests can fake it.
+type aeadCipher interface {
+	Seal(dst, nonce, plaintext, additionalData []byte) []byte
+	Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+	NonceSize() int
+	Overhead() int
+}
+
+func (s *session) needsRekeyLocked() bool {
+	if s.bytesSent >= RekeyAfterBytes {
+		return true
+	}
+	return time.Since(s.establishedAt) >= RekeyAfterDuration
+}
+
+// isInitiatorLocked deterministically picks one side of a peer pair as the
+// "initiator" by comparing identity public keys, so both ends agree on
+// which direction label ("initiator->responder" or the reverse) applies
+// to which flow without any handshake.
+func (s *session) isInitiatorLocked(ourPublic [32]byte) bool {
+	return bytes.Compare(ourPublic[:], s.peerPublic[:]) < 0
+}
+
+// deriveKey expands the (static) ECDH shared secret into an AEAD key,
+// folding in the rekey epoch (as the HKDF salt, so each epoch yields
+// unrelated key material) and a direction label (as the HKDF info, so the
+// two flows of a session never share a key).
+func deriveKey(ourPrivate, peerPublic [32]byte, epoch uint32, label string) (aeadCipher, error) {
+	shared, err := curve25519.X25519(ourPrivate[:], peerPublic[:])
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	var epochSalt [4]byte
+	binary.BigEndian.PutUint32(epochSalt[:], epoch)
+
+	kdf := hkdf.New(sha256.New, shared, epochSalt[:], []byte(label))
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := kdf.Read(key); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return chacha20poly1305.New(key)
+}
+
+// deriveSendLocked (re)derives the send-direction key for the given epoch
+// and resets the send counter -- safe because a new epoch means new key
+// material, so counter 0 under this (epoch, key) pair has never been used.
+func (s *session) deriveSendLocked(ourKeys PeerKeys, epoch uint32) error {
+	label := labelResponderToInitiator
+	if s.isInitiatorLocked(ourKeys.Public) {
+		label = labelInitiatorToResponder
+	}
+
+	aead, err := deriveKey(ourKeys.Private, s.peerPublic, epoch, label)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	s.sendAEAD = aead
+	s.sendEpoch = epoch
+	s.sendCounter = 0
+	s.bytesSent = 0
+	s.establishedAt = time.Now()
+	return nil
+}
+
+// deriveRecvKeyLocked derives (without committing) the receive-direction
+// key for epoch. Callers must not assign the result to s.recvAEAD until
+// they've verified a real datagram opens under it -- see handleDatagram.
+func (s *session) deriveRecvKeyLocked(ourKeys PeerKeys, epoch uint32) (aeadCipher, error) {
+	label := labelInitiatorToResponder
+	if s.isInitiatorLocked(ourKeys.Public) {
+		label = labelResponderToInitiator
+	}
+	return deriveKey(ourKeys.Private, s.peerPublic, epoch, label)
+}
+
+func (s *session) sealLocked(ourAlias uint32, frame []byte) ([]byte, error) {
+	header := make([]byte, headerSize)
+	header[0] = ProtocolVersion
+	binary.BigEndian.PutUint32(header[1:5], ourAlias)
+	binary.BigEndian.PutUint32(header[5:9], s.sendEpoch)
+	binary.BigEndian.PutUint64(header[9:headerSize], s.sendCounter)
+
+	nonce := make([]byte, s.sendAEAD.NonceSize())
+	binary.BigEndian.PutUint64(nonce[s.sendAEAD.NonceSize()-8:], s.sendCounter)
+
+	sealed := s.sendAEAD.Seal(header, nonce, frame, header)
+
+	s.sendCounter++
+	s.bytesSent += uint64(len(frame))
+	if s.needsRekeyLocked() {
+		// Advance the epoch now; the next Send sees needsRekeyLocked and
+		// derives a key for a newer epoch instead of reusing this one past
+		// its budget.
+		s.sendEpoch++
+	}
+	return sealed, nil
+}
+
+func (s *session) openLocked(aead aeadCipher, datagram []byte) ([]byte, error) {
+	header := datagram[:headerSize]
+	ciphertext := datagram[headerSize:]
+	nonceCounter := binary.BigEndian.Uint64(header[9:headerSize])
+
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[aead.NonceSize()-8:], nonceCounter)
+
+	return aead.Open(nil, nonce, ciphertext, header)
+}
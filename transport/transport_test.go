@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(fmt string, args ...interface{}) {}
+func (discardLogger) Print(args ...interface{})              {}
+
+func mustGenerateKeys(t *testing.T) PeerKeys {
+	t.Helper()
+
+	var priv [32]byte
+	if _, err := rand.Read(priv[:]); err != nil {
+		t.Fatalf("rand.Read: %s", err)
+	}
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatalf("curve25519.X25519: %s", err)
+	}
+	var pubArr [32]byte
+	copy(pubArr[:], pub)
+	return PeerKeys{Public: pubArr, Private: priv}
+}
+
+// TestHandleDatagramForgedEpochDoesNotDesyncSession reproduces the bug where
+// an off-path attacker could spoof a single datagram with a real peer's
+// srcAlias and an inflated epoch: epoch is unauthenticated plaintext, so if
+// recvEpoch/recvAEAD were committed before the AEAD tag verifies, the forged
+// (and undecryptable) datagram would still permanently bump recvEpoch,
+// making every subsequent *legitimate* datagram look like a stale-epoch
+// replay. It must instead be dropped with no effect on session state.
+func TestHandleDatagramForgedEpochDoesNotDesyncSession(t *testing.T) {
+	aliceKeys := mustGenerateKeys(t)
+	bobKeys := mustGenerateKeys(t)
+
+	var gotFrames []string
+	bob := &Transport{
+		ourKeys:  bobKeys,
+		ourAlias: 2,
+		onFrame:  func(_ uint32, frame []byte) { gotFrames = append(gotFrames, string(frame)) },
+		logger:   discardLogger{},
+		sessions: map[uint32]*session{1: {peerPublic: aliceKeys.Public}},
+	}
+
+	alice := &session{peerPublic: bobKeys.Public}
+	if err := alice.deriveSendLocked(aliceKeys, 0); err != nil {
+		t.Fatalf("deriveSendLocked: %s", err)
+	}
+
+	seal := func(plaintext string) []byte {
+		datagram, err := alice.sealLocked(1, []byte(plaintext))
+		if err != nil {
+			t.Fatalf("sealLocked(%q): %s", plaintext, err)
+		}
+		return datagram
+	}
+
+	first := seal("first")
+	second := seal("second")
+	third := seal("third")
+
+	if err := bob.handleDatagram(first, nil); err != nil {
+		t.Fatalf("handleDatagram(legit epoch 0): %s", err)
+	}
+
+	forged := append([]byte(nil), second...)
+	binary.BigEndian.PutUint32(forged[5:9], 99)
+	if err := bob.handleDatagram(forged, nil); err == nil {
+		t.Fatalf("handleDatagram(forged epoch 99) returned no error, want a decrypt failure")
+	}
+
+	if err := bob.handleDatagram(third, nil); err != nil {
+		t.Fatalf("handleDatagram(legit epoch 0, after forged epoch 99): %s", err)
+	}
+
+	want := []string{"first", "third"}
+	if len(gotFrames) != len(want) {
+		t.Fatalf("got frames %v, want %v", gotFrames, want)
+	}
+	for i, w := range want {
+		if gotFrames[i] != w {
+			t.Fatalf("got frames %v, want %v", gotFrames, want)
+		}
+	}
+}
+
+// TestHandleDatagramAdvancesEpochOnGenuineRekey checks the companion
+// success path: a real datagram at a higher epoch (a genuine rekey by the
+// peer) must be accepted and become the new current epoch.
+func TestHandleDatagramAdvancesEpochOnGenuineRekey(t *testing.T) {
+	aliceKeys := mustGenerateKeys(t)
+	bobKeys := mustGenerateKeys(t)
+
+	var gotFrames []string
+	bob := &Transport{
+		ourKeys:  bobKeys,
+		ourAlias: 2,
+		onFrame:  func(_ uint32, frame []byte) { gotFrames = append(gotFrames, string(frame)) },
+		logger:   discardLogger{},
+		sessions: map[uint32]*session{1: {peerPublic: aliceKeys.Public}},
+	}
+
+	alice := &session{peerPublic: bobKeys.Public}
+	seal := func(plaintext string) []byte {
+		datagram, err := alice.sealLocked(1, []byte(plaintext))
+		if err != nil {
+			t.Fatalf("sealLocked(%q): %s", plaintext, err)
+		}
+		return datagram
+	}
+
+	if err := alice.deriveSendLocked(aliceKeys, 0); err != nil {
+		t.Fatalf("deriveSendLocked(epoch 0): %s", err)
+	}
+	if err := bob.handleDatagram(seal("before rekey"), nil); err != nil {
+		t.Fatalf("handleDatagram(epoch 0): %s", err)
+	}
+
+	if err := alice.deriveSendLocked(aliceKeys, 1); err != nil {
+		t.Fatalf("deriveSendLocked(epoch 1): %s", err)
+	}
+	if err := bob.handleDatagram(seal("after rekey"), nil); err != nil {
+		t.Fatalf("handleDatagram(epoch 1): %s", err)
+	}
+
+	want := []string{"before rekey", "after rekey"}
+	if len(gotFrames) != len(want) || gotFrames[0] != want[0] || gotFrames[1] != want[1] {
+		t.Fatalf("got frames %v, want %v", gotFrames, want)
+	}
+	if bob.sessions[1].recvEpoch != 1 {
+		t.Fatalf("bob's recvEpoch = %d, want 1", bob.sessions[1].recvEpoch)
+	}
+}
@@ -0,0 +1,20 @@
+package vpn
+
+import (
+	"github.com/xaionaro-go/homenet-peer/connector"
+)
+
+// optSetCalculatedRemoteRules carries the rules, see OptSetCalculatedRemoteRules.
+type optSetCalculatedRemoteRules struct {
+	rules []connector.CalculatedRemoteRule
+}
+
+// OptSetCalculatedRemoteRules makes updatePeers add each peer's synthesized
+// calculated-remote endpoint (see connector.CalculatedRemotes) to the
+// candidates tried alongside its arbitr-registered endpoint, or on their own
+// for a peer with no registered endpoint. The rules are only used when
+// OptSetEndpointNegotiator is also set. Without this option, only the
+// registered endpoint is ever tried.
+func OptSetCalculatedRemoteRules(rules []connector.CalculatedRemoteRule) Option {
+	return optSetCalculatedRemoteRules{rules: rules}
+}
@@ -0,0 +1,28 @@
+package vpn
+
+import (
+	"net"
+
+	"github.com/xaionaro-go/homenet-peer/nat"
+)
+
+// EndpointNegotiator is implemented by connector.NATTraversal: given a
+// peer's arbitr/DHT-learned candidates, it hole-punches and returns the
+// endpoint that actually round-trips, falling back to the caller's own
+// judgement (e.g. the peer's registered endpoint) on error.
+type EndpointNegotiator interface {
+	NegotiateEndpoint(peerID string, hostAddr *net.UDPAddr, remoteCandidates []nat.Candidate, publish func([]nat.Candidate) error) (*net.UDPAddr, error)
+}
+
+// optSetEndpointNegotiator carries the negotiator, see OptSetEndpointNegotiator.
+type optSetEndpointNegotiator struct {
+	negotiator EndpointNegotiator
+}
+
+// OptSetEndpointNegotiator makes updatePeers run every peer's registered
+// endpoint through negotiator before handing it to the transport, instead
+// of trusting it as-is. Without this option, peer endpoints are used
+// exactly as the arbitr/DHT reported them.
+func OptSetEndpointNegotiator(negotiator EndpointNegotiator) Option {
+	return optSetEndpointNegotiator{negotiator: negotiator}
+}
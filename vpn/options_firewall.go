@@ -0,0 +1,15 @@
+package vpn
+
+import "github.com/xaionaro-go/homenet-peer/firewall"
+
+// optSetFirewallTable carries the firewall rule table, see OptSetFirewallTable.
+type optSetFirewallTable struct {
+	table *firewall.Table
+}
+
+// OptSetFirewallTable attaches a firewall.Table that will be consulted for
+// every egress frame read from the TAP device and every ingress frame
+// received from a peer. Without this option, frames pass unfiltered.
+func OptSetFirewallTable(table *firewall.Table) Option {
+	return optSetFirewallTable{table: table}
+}
@@ -0,0 +1,13 @@
+package vpn
+
+// optSetListenAddr carries the UDP address the datapath transport should
+// bind to, see OptSetListenAddr.
+type optSetListenAddr struct {
+	addr string
+}
+
+// OptSetListenAddr overrides DefaultListenAddr, useful when multiple
+// homenet-peer instances share a host.
+func OptSetListenAddr(addr string) Option {
+	return optSetListenAddr{addr: addr}
+}
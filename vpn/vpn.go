@@ -1,8 +1,9 @@
 package vpn
 
 import (
-	"fmt"
+	"math/big"
 	"net"
+	"net/netip"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,26 +16,40 @@ import (
 
 	"github.com/xaionaro-go/homenet-server/models"
 
+	"github.com/xaionaro-go/homenet-peer/connector"
+	"github.com/xaionaro-go/homenet-peer/firewall"
+	"github.com/xaionaro-go/homenet-peer/nat"
 	"github.com/xaionaro-go/homenet-peer/network"
+	"github.com/xaionaro-go/homenet-peer/transport"
 )
 
 const (
 	TAPFrameMaxSize = 1500
+
+	// DefaultListenAddr is the UDP socket the datapath transport binds to
+	// unless overridden with OptSetListenAddr.
+	DefaultListenAddr = ":12345"
 )
 
 var (
-	ErrWrongMask    = errors.New("Invalid mask")
-	ErrPeerNotFound = errors.NotFound.New("peer not found")
+	ErrWrongMask        = errors.New("Invalid mask")
+	ErrPeerNotFound     = errors.NotFound.New("peer not found")
+	ErrAddressCollision = errors.New("computed address collides with the network or broadcast address")
 )
 
 type vpn struct {
-	network         atomic.Value
-	oldPeerIntAlias uint32
-	closeChan       chan struct{}
-	tapIface        *water.Interface
-	tapLink         tenus.Linker
-	subnet          net.IPNet
-	locker          sync.Mutex
+	network            atomic.Value
+	oldPeerIntAlias    uint32
+	closeChan          chan struct{}
+	tapIface           *water.Interface
+	tapLink            tenus.Linker
+	subnet             net.IPNet
+	locker             sync.Mutex
+	listenAddr         string
+	transport          *transport.Transport
+	firewallTable      *firewall.Table
+	endpointNegotiator EndpointNegotiator
+	calculatedRemotes  []connector.CalculatedRemoteRule
 
 	loggerError Logger
 	loggerDump  Logger
@@ -44,12 +59,21 @@ func New(subnet net.IPNet, homenet *network.Network, opts ...Option) (r *vpn, er
 	r = &vpn{
 		subnet:      subnet,
 		loggerError: &errorLogger{},
+		listenAddr:  DefaultListenAddr,
 	}
 
 	for _, optI := range opts {
 		switch opt := optI.(type) {
 		case optSetLoggerDump:
 			r.loggerDump = opt.logger
+		case optSetListenAddr:
+			r.listenAddr = opt.addr
+		case optSetFirewallTable:
+			r.firewallTable = opt.table
+		case optSetEndpointNegotiator:
+			r.endpointNegotiator = opt.negotiator
+		case optSetCalculatedRemoteRules:
+			r.calculatedRemotes = opt.rules
 		}
 	}
 
@@ -65,10 +89,41 @@ func New(subnet net.IPNet, homenet *network.Network, opts ...Option) (r *vpn, er
 	}
 	r.setNetwork(homenet)
 
+	identity := homenet.GetIdentity()
+	r.transport, err = transport.New(r.listenAddr, homenet.GetPeerIntAlias(), transport.PeerKeys{
+		Public:  identity.Keys.Public,
+		Private: identity.Keys.Private,
+	}, r.onDecryptedFrame, r.loggerError)
+	if err != nil {
+		return
+	}
+
 	go r.tapReadHandler()
 	return
 }
 
+// onDecryptedFrame is handed to transport.New as the FrameHandler: it takes
+// a frame that has already been decrypted and authenticated and injects it
+// into the TAP device, exactly like a frame we'd captured locally.
+func (vpn *vpn) onDecryptedFrame(srcPeerIntAlias uint32, frame []byte) {
+	vpn.ifDump(func(log Logger) {
+		log.Printf("<<<\tPeer int alias: %v\n\tPayload: % x\n", srcPeerIntAlias, frame)
+	})
+
+	ethFrame := ethernet.Frame(frame)
+	srcPeerName := ""
+	if peer := vpn.GetNetwork().GetPeerByIntAlias(srcPeerIntAlias); peer != nil {
+		srcPeerName = peer.GetID()
+	}
+	if !vpn.checkIngress(ethFrame.Ethertype(), ethFrame.Payload(), srcPeerName) {
+		return
+	}
+
+	if _, err := vpn.tapIface.Write(frame); err != nil {
+		vpn.loggerError.Printf("unable to write a frame from peer %v to %s: %s", srcPeerIntAlias, vpn.tapIface.Name(), err)
+	}
+}
+
 func (vpn *vpn) LockDo(fn func()) {
 	vpn.locker.Lock()
 	defer vpn.locker.Unlock()
@@ -147,13 +202,19 @@ func (vpn *vpn) tapReadHandler() {
 		}
 
 		if isHomenetDST {
-			logIfError(vpn.SendToPeerByIntAlias(dstMAC.GetPeerIntAlias(), frame))
+			peerIntAlias := dstMAC.GetPeerIntAlias()
+			peer := vpn.GetNetwork().GetPeerByIntAlias(peerIntAlias)
+			if peer == nil || vpn.checkEgress(frame, peer) {
+				logIfError(vpn.SendToPeerByIntAlias(peerIntAlias, frame))
+			}
 			continue
 		}
 
 		if isBroadcastDST {
 			vpn.ForeachPeer(func(peer *models.PeerT) bool {
-				logIfError(vpn.SendToPeer(peer, frame))
+				if vpn.checkEgress(frame, peer) {
+					logIfError(vpn.SendToPeer(peer, frame))
+				}
 				return true
 			})
 			continue
@@ -163,6 +224,40 @@ func (vpn *vpn) tapReadHandler() {
 	}
 }
 
+// checkEgress reports whether frame is allowed to leave towards peer. With
+// no firewall table configured, everything is allowed, matching the
+// previous (unfiltered) behaviour.
+func (vpn *vpn) checkEgress(frame ethernet.Frame, peer *models.PeerT) bool {
+	if vpn.firewallTable == nil {
+		return true
+	}
+	pkt, ok := firewall.ParsePacket(uint16FromEthertype(frame.Ethertype()), frame.Payload(), true)
+	if !ok {
+		return true
+	}
+	return vpn.firewallTable.AllowEgress(pkt, peer.GetID())
+}
+
+// checkIngress reports whether a frame just decrypted from the peer named
+// srcPeerName is allowed to be written into the TAP device.
+func (vpn *vpn) checkIngress(ethertype []byte, payload []byte, srcPeerName string) bool {
+	if vpn.firewallTable == nil {
+		return true
+	}
+	pkt, ok := firewall.ParsePacket(uint16FromEthertype(ethertype), payload, false)
+	if !ok {
+		return true
+	}
+	return vpn.firewallTable.AllowIngress(pkt, srcPeerName)
+}
+
+func uint16FromEthertype(ethertype []byte) uint16 {
+	if len(ethertype) != 2 {
+		return 0
+	}
+	return uint16(ethertype[0])<<8 | uint16(ethertype[1])
+}
+
 func (vpn *vpn) ifDump(fn func(Logger)) {
 	if vpn.loggerDump == nil {
 		return
@@ -185,7 +280,7 @@ func (vpn *vpn) SendToPeer(peer *models.PeerT, frame ethernet.Frame) error {
 			frame.Payload(),
 		)
 	})
-	return nil
+	return errors.Wrap(vpn.transport.Send(peer.GetIntAlias(), frame))
 }
 
 func (vpn *vpn) SendToPeerByIntAlias(peerIntAlias uint32, frame []byte) error {
@@ -196,6 +291,12 @@ func (vpn *vpn) SendToPeerByIntAlias(peerIntAlias uint32, frame []byte) error {
 	return errors.Wrap(vpn.SendToPeer(peer, frame))
 }
 
+// GetListenAddr returns the UDP address the datapath transport is bound
+// to, e.g. to be announced through a discovery.Backend.
+func (vpn *vpn) GetListenAddr() string {
+	return vpn.listenAddr
+}
+
 func (vpn *vpn) ForeachPeer(fn func(peer *models.PeerT) bool) {
 	homenet := vpn.GetNetwork()
 	myPeerID := homenet.GetPeerID()
@@ -214,6 +315,9 @@ func (vpn *vpn) Close() {
 	vpn.LockDo(func() {
 		vpn.setNetwork(nil)
 		vpn.tapIface.Close()
+		if vpn.transport != nil {
+			vpn.transport.Close()
+		}
 		vpn.closeChan <- struct{}{}
 	})
 }
@@ -228,19 +332,59 @@ func (vpn *vpn) updateMAC(peerIntAlias uint32) error {
 	return nil
 }
 
-func (vpn *vpn) updateIPAddress(peerIntAlias uint32) error {
-	maskOnes, maskBits := vpn.subnet.Mask.Size()
-	if peerIntAlias >= 1<<uint32(maskBits-maskOnes) {
-		return errors.Wrap(ErrWrongMask)
+// computePeerAddress allocates the address for peerIntAlias within subnet
+// by adding it, as a variable-width integer, to the network address with
+// byte-wise carry across the whole host portion -- not just the last
+// octet. It works for any prefix length on either IPv4 or IPv6 (e.g. IPv4
+// /30../8 or IPv6 /64, /96, /112), and rejects aliases that would collide
+// with the network or broadcast/all-ones address.
+func computePeerAddress(subnet net.IPNet, peerIntAlias uint32) (netip.Addr, error) {
+	prefix, err := netip.ParsePrefix(subnet.String())
+	if err != nil {
+		return netip.Addr{}, errors.Wrap(err)
 	}
 
-	myAddress := vpn.subnet.IP
-	if uint32(myAddress[len(myAddress)-1])+peerIntAlias > 255 {
-		return fmt.Errorf("Not implemented yet: we can only modify the last octet at the moment")
+	addrBits := prefix.Addr().BitLen()
+	hostBits := addrBits - prefix.Bits()
+	if hostBits <= 1 {
+		return netip.Addr{}, errors.Wrap(ErrWrongMask)
 	}
-	myAddress[len(myAddress)-1] += uint8(peerIntAlias)
 
-	if err := vpn.tapLink.SetLinkIp(myAddress, &vpn.subnet); err != nil {
+	hostMax := new(big.Int).Lsh(big.NewInt(1), uint(hostBits))
+	hostMax.Sub(hostMax, big.NewInt(1))
+
+	maxPeerIntAlias := new(big.Int).Sub(hostMax, big.NewInt(1))
+	if new(big.Int).SetUint64(uint64(peerIntAlias)).Cmp(maxPeerIntAlias) >= 0 {
+		return netip.Addr{}, errors.Wrap(ErrWrongMask, "integer alias", peerIntAlias, "max", maxPeerIntAlias)
+	}
+
+	networkAddr := prefix.Masked().Addr()
+	networkBytes := networkAddr.AsSlice()
+	networkInt := new(big.Int).SetBytes(networkBytes)
+
+	addrInt := new(big.Int).Add(networkInt, new(big.Int).SetUint64(uint64(peerIntAlias)))
+	broadcastInt := new(big.Int).Add(networkInt, hostMax)
+	if addrInt.Cmp(networkInt) == 0 || addrInt.Cmp(broadcastInt) == 0 {
+		return netip.Addr{}, errors.Wrap(ErrAddressCollision, "integer alias", peerIntAlias)
+	}
+
+	addrBytes := make([]byte, len(networkBytes))
+	addrInt.FillBytes(addrBytes)
+
+	addr, ok := netip.AddrFromSlice(addrBytes)
+	if !ok {
+		return netip.Addr{}, errors.Wrap(ErrWrongMask, "unable to build an address from the computed bytes")
+	}
+	return addr, nil
+}
+
+func (vpn *vpn) updateIPAddress(peerIntAlias uint32) error {
+	addr, err := computePeerAddress(vpn.subnet, peerIntAlias)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := vpn.tapLink.SetLinkIp(net.IP(addr.AsSlice()), &vpn.subnet); err != nil {
 		return errors.Wrap(err)
 	}
 
@@ -273,6 +417,45 @@ func (vpn *vpn) updatePeers(peers models.Peers) error {
 		}
 	}
 
+	var hostAddr *net.UDPAddr
+	if vpn.endpointNegotiator != nil {
+		hostAddr, _ = net.ResolveUDPAddr("udp", vpn.listenAddr)
+	}
+
+	myPeerID := vpn.GetPeerID()
+	for _, peer := range peers {
+		if peer.GetID() == myPeerID {
+			continue
+		}
+		endpoint := peer.GetEndpoint()
+		if endpoint == nil && (vpn.endpointNegotiator == nil || len(vpn.calculatedRemotes) == 0) {
+			continue
+		}
+
+		if vpn.endpointNegotiator != nil {
+			var remoteCandidates []nat.Candidate
+			if endpoint != nil {
+				remoteCandidates = append(remoteCandidates, nat.Candidate{Type: nat.CandidateHost, Addr: endpoint})
+			}
+			if len(vpn.calculatedRemotes) > 0 {
+				if peerAddr, err := computePeerAddress(vpn.subnet, peer.GetIntAlias()); err == nil {
+					remoteCandidates = append(remoteCandidates, connector.CalculatedRemotes(vpn.calculatedRemotes, net.IP(peerAddr.AsSlice()))...)
+				}
+			}
+			negotiated, err := vpn.endpointNegotiator.NegotiateEndpoint(peer.GetID(), hostAddr, remoteCandidates, nil)
+			if err != nil {
+				vpn.loggerError.Printf("NAT traversal to peer %v (%s) failed, falling back to its registered endpoint (if any): %s", peer.GetIntAlias(), peer.GetID(), err)
+			} else {
+				endpoint = negotiated
+			}
+		}
+		if endpoint == nil {
+			continue
+		}
+
+		vpn.transport.SetPeerEndpoint(peer.GetIntAlias(), endpoint, peer.GetPublicKey())
+	}
+
 	return nil
 }
 
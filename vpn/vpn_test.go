@@ -0,0 +1,66 @@
+package vpn
+
+import (
+	"net"
+	"testing"
+)
+
+func mustParseIPNet(t *testing.T, cidr string) net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("net.ParseCIDR(%q): %s", cidr, err)
+	}
+	return *n
+}
+
+func TestComputePeerAddress(t *testing.T) {
+	tests := []struct {
+		name         string
+		subnet       string
+		peerIntAlias uint32
+		wantAddr     string
+		wantErr      bool
+	}{
+		{name: "ipv4 /30 first usable", subnet: "10.0.0.0/30", peerIntAlias: 1, wantAddr: "10.0.0.1"},
+		{name: "ipv4 /30 network collision", subnet: "10.0.0.0/30", peerIntAlias: 0, wantErr: true},
+		{name: "ipv4 /30 broadcast collision", subnet: "10.0.0.0/30", peerIntAlias: 3, wantErr: true},
+		{name: "ipv4 /30 out of range", subnet: "10.0.0.0/30", peerIntAlias: 4, wantErr: true},
+
+		{name: "ipv4 /24", subnet: "10.10.0.0/24", peerIntAlias: 42, wantAddr: "10.10.0.42"},
+		{name: "ipv4 /24 out of range", subnet: "10.10.0.0/24", peerIntAlias: 255, wantErr: true},
+
+		{name: "ipv4 /16 carries into second octet", subnet: "10.10.0.0/16", peerIntAlias: 256, wantAddr: "10.10.1.0"},
+		{name: "ipv4 /16", subnet: "10.10.0.0/16", peerIntAlias: 65500, wantAddr: "10.10.255.220"},
+
+		{name: "ipv4 /8 carries across three octets", subnet: "10.0.0.0/8", peerIntAlias: 1<<16 + 1, wantAddr: "10.1.0.1"},
+
+		{name: "ipv6 /64", subnet: "fd00::/64", peerIntAlias: 4, wantAddr: "fd00::4"},
+		{name: "ipv6 /64 network collision", subnet: "fd00::/64", peerIntAlias: 0, wantErr: true},
+
+		{name: "ipv6 /96", subnet: "fd00::/96", peerIntAlias: 300, wantAddr: "fd00::12c"},
+		{name: "ipv6 /96 out of range", subnet: "fd00::/96", peerIntAlias: 1<<32 - 1, wantErr: true},
+
+		{name: "ipv6 /112", subnet: "fd00::/112", peerIntAlias: 65534, wantErr: true}, // out of range: must be below 2^16-2
+		{name: "ipv6 /112 usable", subnet: "fd00::/112", peerIntAlias: 10, wantAddr: "fd00::a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subnet := mustParseIPNet(t, tt.subnet)
+			addr, err := computePeerAddress(subnet, tt.peerIntAlias)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("computePeerAddress(%q, %d) = %s, want error", tt.subnet, tt.peerIntAlias, addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("computePeerAddress(%q, %d) returned error: %s", tt.subnet, tt.peerIntAlias, err)
+			}
+			if addr.String() != tt.wantAddr {
+				t.Fatalf("computePeerAddress(%q, %d) = %s, want %s", tt.subnet, tt.peerIntAlias, addr, tt.wantAddr)
+			}
+		})
+	}
+}